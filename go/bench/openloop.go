@@ -0,0 +1,136 @@
+package bench
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A run is considered sustained if the achieved rate is at least this share
+// of the target rate and no operation failed.
+const SUSTAINED_RATE_RATIO = 0.95
+
+type OpenLoopConfig struct {
+	Rate      float64       // target operations per second
+	Duration  time.Duration // how long operations are scheduled for
+	RowsPerOp int           // rows handled by a single operation, used to report rows/s
+	// Number of operations allowed to run at the same time. Keep this at 1 for
+	// the PostgresDB, because it holds a single pgx connection which can not
+	// be used concurrently.
+	MaxInFlight int
+}
+
+type OpenLoopResult struct {
+	Scheduled    int
+	Completed    int
+	Errors       int
+	FirstErr     error
+	Elapsed      time.Duration
+	TargetRate   float64 // operations per second
+	AchievedRate float64 // operations per second
+	RowsPerSec   float64
+	MaxStartLag  time.Duration // how far behind the schedule an operation was started
+	Latency      LatencySummary
+	Sustained    bool
+}
+
+// RunOpenLoop issues op at a fixed rate, independent of how long the previous
+// operations took. The latency of every operation is measured from the time it
+// was supposed to start, not from the time it actually started, so a stalling
+// database shows up in the percentiles instead of just lowering the number of
+// executed operations (coordinated omission).
+//
+// op receives the sequence number of the operation, which can be used to pick
+// the data for it. Any db.Database method can be wrapped in a closure.
+func RunOpenLoop(cfg OpenLoopConfig, op func(i int) error) (OpenLoopResult, error) {
+	if cfg.Rate <= 0 {
+		return OpenLoopResult{}, fmt.Errorf("rate must be positive, got %v", cfg.Rate)
+	}
+
+	if cfg.Duration <= 0 {
+		return OpenLoopResult{}, fmt.Errorf("duration must be positive, got %v", cfg.Duration)
+	}
+
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 1
+	}
+
+	if cfg.RowsPerOp <= 0 {
+		cfg.RowsPerOp = 1
+	}
+
+	interval := time.Duration(float64(time.Second) / cfg.Rate)
+	scheduled := int(cfg.Duration / interval)
+	if scheduled == 0 {
+		return OpenLoopResult{}, fmt.Errorf("rate %v is too low for a duration of %v", cfg.Rate, cfg.Duration)
+	}
+
+	var (
+		latencies = make([]time.Duration, scheduled)
+		startLags = make([]time.Duration, scheduled)
+		errCount  atomic.Int64
+		firstErr  error
+		errOnce   sync.Once
+		wg        sync.WaitGroup
+		slots     = make(chan struct{}, cfg.MaxInFlight)
+	)
+
+	start := time.Now()
+
+	for i := range scheduled {
+		intended := start.Add(time.Duration(i) * interval)
+		if wait := time.Until(intended); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		// Waiting for a free slot is part of the latency, because the
+		// operation should have been started at the intended time.
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(i int, intended time.Time) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			startLags[i] = time.Since(intended)
+			err := op(i)
+			latencies[i] = time.Since(intended)
+
+			if err != nil {
+				errCount.Add(1)
+				errOnce.Do(func() { firstErr = err })
+			}
+		}(i, intended)
+	}
+
+	wg.Wait()
+
+	// The schedule covers scheduled*interval, even if the last operation
+	// finished before the end of its slot.
+	elapsed := max(time.Since(start), time.Duration(scheduled)*interval)
+
+	res := OpenLoopResult{
+		Scheduled:    scheduled,
+		Completed:    scheduled - int(errCount.Load()),
+		Errors:       int(errCount.Load()),
+		FirstErr:     firstErr,
+		Elapsed:      elapsed,
+		TargetRate:   cfg.Rate,
+		AchievedRate: float64(scheduled) / elapsed.Seconds(),
+		Latency:      Summarize(latencies),
+	}
+
+	res.RowsPerSec = res.AchievedRate * float64(cfg.RowsPerOp)
+	res.MaxStartLag = Summarize(startLags).Max
+	res.Sustained = res.Errors == 0 && res.AchievedRate >= cfg.Rate*SUSTAINED_RATE_RATIO
+
+	return res, nil
+}
+
+func (r OpenLoopResult) String() string {
+	return fmt.Sprintf("target=%.0f ops/s achieved=%.0f ops/s (%.0f rows/s) sustained=%v errors=%v max-start-lag=%v latency: %v",
+		r.TargetRate, r.AchievedRate, r.RowsPerSec, r.Sustained, r.Errors, r.MaxStartLag, r.Latency)
+}
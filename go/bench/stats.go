@@ -0,0 +1,56 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+type LatencySummary struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+// Summarize sorts a copy of the latencies and returns the percentiles.
+func Summarize(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+
+	return LatencySummary{
+		Count: len(sorted),
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 0.50),
+		P90:   percentile(sorted, 0.90),
+		P99:   percentile(sorted, 0.99),
+		P999:  percentile(sorted, 0.999),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile uses the nearest-rank method on an already sorted slice: the
+// smallest value which is at least the share p of the values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(float64(len(sorted))*p)) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+func (s LatencySummary) String() string {
+	return fmt.Sprintf("n=%v mean=%v p50=%v p90=%v p99=%v p999=%v max=%v",
+		s.Count, s.Mean, s.P50, s.P90, s.P99, s.P999, s.Max)
+}
@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ten := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	tests := []struct {
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{ten, 0.50, 5},
+		{ten, 0.90, 9},
+		// The nearest rank is the ceiling, rounding would give the 9th value.
+		{ten, 0.91, 10},
+		{ten, 0.99, 10},
+		{ten, 0.01, 1},
+		{ten, 0, 1},
+		{[]time.Duration{7}, 0.999, 7},
+		{[]time.Duration{1, 2, 3}, 0.5, 2},
+	}
+
+	for _, test := range tests {
+		if got := percentile(test.sorted, test.p); got != test.want {
+			t.Errorf("percentile(%v, %v): expected %v, got %v", test.sorted, test.p, test.want, got)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		latencies []time.Duration
+		want      LatencySummary
+	}{
+		{nil, LatencySummary{}},
+		{[]time.Duration{5}, LatencySummary{Count: 1, Mean: 5, P50: 5, P90: 5, P99: 5, P999: 5, Max: 5}},
+		{
+			[]time.Duration{10, 1, 9, 2, 8, 3, 7, 4, 6, 5},
+			LatencySummary{Count: 10, Mean: 5, P50: 5, P90: 9, P99: 10, P999: 10, Max: 10},
+		},
+	}
+
+	for _, test := range tests {
+		if got := Summarize(test.latencies); got != test.want {
+			t.Errorf("Summarize(%v): expected %v, got %v", test.latencies, test.want, got)
+		}
+	}
+}
+
+// An operation which is slower than the schedule has to show up in the
+// latencies, which are measured from the intended start of every operation
+// instead of from its actual start.
+func TestRunOpenLoop(t *testing.T) {
+	const (
+		OPS      = 10
+		OP_SLEEP = 20 * time.Millisecond
+	)
+
+	res, err := RunOpenLoop(OpenLoopConfig{Rate: 100, Duration: OPS * 10 * time.Millisecond}, func(i int) error {
+		time.Sleep(OP_SLEEP)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if res.Scheduled != OPS || res.Completed != OPS || res.Errors != 0 {
+		t.Errorf("expected %v completed operations, got %+v", OPS, res)
+	}
+
+	// The last operation starts after the 9 before it, (OPS-1)*OP_SLEEP
+	// after the start, but was scheduled (OPS-1)*10ms after the start.
+	wantMax := OPS*OP_SLEEP - (OPS-1)*10*time.Millisecond
+	if res.Latency.Max < wantMax {
+		t.Errorf("expected a max latency of at least %v, got %v", wantMax, res.Latency.Max)
+	}
+	if res.Latency.P50 <= OP_SLEEP {
+		t.Errorf("expected the queueing in the median latency, got %v", res.Latency.P50)
+	}
+	if res.Sustained {
+		t.Errorf("expected the rate not to be sustained: %v", res)
+	}
+}
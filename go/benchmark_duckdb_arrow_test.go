@@ -0,0 +1,135 @@
+//go:build !no_duckdb_arrow
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"timeseries-benchmark/db"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// The arrow read path of duckdb next to the row based reads through
+// database/sql. Both of the read paths sum up the values, so every row is
+// touched once.
+func BenchmarkDuckDBArrow(b *testing.B) {
+	duckDb, err := db.NewDuckDB("duckdb", "./duckdb.db")
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer duckDb.Close()
+
+	NUM_OBJECTS := 1_000_000
+	LIMITS := []int{4_000, 100_000, NUM_OBJECTS}
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+	from, to := fake[0].StartTime, fake[len(fake)-1].StartTime.Add(time.Hour)
+
+	if err := duckDb.Setup(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	if err := duckDb.UpsertBulk(fake); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	for _, limit := range LIMITS {
+		b.Run(fmt.Sprintf("%v-get-rows-%v", duckDb.GetName(), limit), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				docs, err := duckDb.GetOrderedWithLimit(limit)
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				sum := 0.0
+				for _, doc := range docs {
+					sum += doc.Value.(float64)
+				}
+
+				if len(docs) != limit {
+					b.Fatalf("Expected %v docs, got %v", limit, len(docs))
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v-get-arrow-%v", duckDb.GetName(), limit), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reader, err := duckDb.GetOrderedWithLimitArrow(limit)
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				if count := sumArrowValues(reader, "value"); count != limit {
+					b.Fatalf("Expected %v rows, got %v", limit, count)
+				}
+			}
+		})
+	}
+
+	b.Run(fmt.Sprintf("%v-range-arrow-%v", duckDb.GetName(), NUM_OBJECTS), func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			reader, err := duckDb.GetRangeArrow(from, to)
+			if err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			if count := sumArrowValues(reader, "value"); count != NUM_OBJECTS {
+				b.Fatalf("Expected %v rows, got %v", NUM_OBJECTS, count)
+			}
+		}
+	})
+
+	for _, bucket := range []db.Bucket{db.BUCKET_HOUR, db.BUCKET_DAY} {
+		b.Run(fmt.Sprintf("%v-aggregate-%v", duckDb.GetName(), bucket), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rows, err := duckDb.GetAggregated(bucket, from, to)
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				sum := 0.0
+				for _, row := range rows {
+					sum += row.Avg
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v-aggregate-arrow-%v", duckDb.GetName(), bucket), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reader, err := duckDb.GetAggregatedArrow(bucket, from, to)
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				sumArrowValues(reader, "avg")
+			}
+		})
+	}
+}
+
+// sumArrowValues sums up the float64 column of every record batch, releases
+// the reader and returns the number of rows.
+func sumArrowValues(reader array.RecordReader, column string) int {
+	defer reader.Release()
+
+	count := 0
+	sum := 0.0
+	for reader.Next() {
+		rec := reader.Record()
+		idx := rec.Schema().FieldIndices(column)[0]
+		values := rec.Column(idx).(*array.Float64)
+		for _, v := range values.Float64Values() {
+			sum += v
+		}
+
+		count += int(rec.NumRows())
+	}
+
+	return count
+}
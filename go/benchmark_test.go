@@ -2,50 +2,30 @@ package main
 
 import (
 	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"timeseries-benchmark/bench"
 	"timeseries-benchmark/db"
 )
 
 func BenchmarkTimeseries(b *testing.B) {
-	mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
-	if err != nil {
-		b.Fatalf("Error: %v", err)
-	}
-	defer mongo.Close()
-
-	pgNative, err := db.NewPostgresDB("pg-ntv", "localhost", db.PORT_POSTGRES, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, false)
-	if err != nil {
-		b.Fatalf("Error: %v", err)
-	}
-	defer pgNative.Close()
-
-	pgTimescale, err := db.NewPostgresDB("pg-tsc", "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
-	if err != nil {
-		b.Fatalf("Error: %v", err)
-	}
-	defer pgTimescale.Close()
-
-	dbMysql, err := db.NewMySQLDB("mysql", "localhost", db.PORT_MYSQL, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME)
-	if err != nil {
-		b.Fatalf("Error: %v", err)
-	}
-	defer dbMysql.Close()
+	conns := connectDatabases(b)
+	defer conns.Close()
 
-	duckDb, err := db.NewDuckDB("duckdb", "./duckdb.db")
-	if err != nil {
-		b.Fatalf("Error: %v", err)
-	}
+	pgTimescale := conns.pgTimescale
 
-	NUM_OBJECTS := 100_000
 	UPDATE_AND_READ_LIMIT := 4_000
-	fake := db.GenerateFakeData(NUM_OBJECTS)
+	fake := benchmarkData(b, 100_000, UPDATE_AND_READ_LIMIT)
+	NUM_OBJECTS := len(fake)
 
-	var dbs []db.Database
-	dbs = append(dbs, dbMysql)
-	dbs = append(dbs, mongo)
-	dbs = append(dbs, pgNative)
-	dbs = append(dbs, pgTimescale)
-	dbs = append(dbs, duckDb)
+	dbs := conns.All()
 
 	// Initialize all of the dbs only once
 	for _, dbInstance := range dbs {
@@ -100,30 +80,1165 @@ func BenchmarkTimeseries(b *testing.B) {
 
 	for _, dbInstance := range dbs {
 		b.Run(fmt.Sprintf("%v-get-%v", dbInstance.GetName(), UPDATE_AND_READ_LIMIT), func(b *testing.B) {
+			benchmarkGetOrdered(b, dbInstance, UPDATE_AND_READ_LIMIT)
+		})
+	}
+
+	for _, dbInstance := range dbs {
+		b.Run(fmt.Sprintf("%v-get-one-random", dbInstance.GetName()), func(b *testing.B) {
+			benchmarkGetOne(b, dbInstance, fake)
+		})
+	}
+
+	b.Logf(" * storage size for %v rows", NUM_OBJECTS)
+	logStorageSizes(b, dbs)
+
+	logMongoStorageStats(b, conns.mongo)
+	logCompressionStats(b, pgTimescale)
+}
+
+// Upserts issued at a fixed rate instead of in a closed b.N loop. The latency of
+// every operation is measured from the time it was scheduled, so stalls of the
+// database are visible in the percentiles.
+func BenchmarkOpenLoop(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	NUM_OBJECTS := 100_000
+	TARGET_ROWS_PER_SEC := 5_000
+	BULK_SIZE := 100
+	DURATION := 10 * time.Second
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+
+	dbs := conns.All()
+
+	for _, dbInstance := range dbs {
+		if err := dbInstance.Setup(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	for _, dbInstance := range dbs {
+		b.Run(fmt.Sprintf("%v-open-loop-upsert-single-%v-rows-per-sec", dbInstance.GetName(), TARGET_ROWS_PER_SEC), func(b *testing.B) {
+			cfg := bench.OpenLoopConfig{Rate: float64(TARGET_ROWS_PER_SEC), Duration: DURATION, RowsPerOp: 1}
+			res, err := bench.RunOpenLoop(cfg, func(i int) error {
+				j := i % len(fake)
+				return dbInstance.UpsertSingle(fake[j : j+1])
+			})
+			if err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			reportOpenLoop(b, res)
+		})
+	}
+
+	for _, dbInstance := range dbs {
+		b.Run(fmt.Sprintf("%v-open-loop-upsert-bulk-%v-rows-per-sec", dbInstance.GetName(), TARGET_ROWS_PER_SEC), func(b *testing.B) {
+			cfg := bench.OpenLoopConfig{Rate: float64(TARGET_ROWS_PER_SEC / BULK_SIZE), Duration: DURATION, RowsPerOp: BULK_SIZE}
+			res, err := bench.RunOpenLoop(cfg, func(i int) error {
+				j := (i * BULK_SIZE) % (len(fake) - BULK_SIZE)
+				return dbInstance.UpsertBulk(fake[j : j+BULK_SIZE])
+			})
+			if err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			reportOpenLoop(b, res)
+		})
+	}
+}
+
+// Live stream of new hours mixed with corrections of historical rows. For every
+// correction window the tables are reloaded, and timescale is compressed before
+// the stream starts, so the corrections hit compressed chunks.
+func BenchmarkCorrections(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	NUM_OBJECTS := 100_000
+	BATCH_SIZE := 1_000
+	CORRECTION_RATIO := 0.2
+	CORRECTION_WINDOWS := []time.Duration{7 * 24 * time.Hour, 180 * 24 * time.Hour}
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+
+	for _, window := range CORRECTION_WINDOWS {
+		for _, dbInstance := range conns.All() {
+			if err := dbInstance.Setup(); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			if err := dbInstance.UpsertBulk(fake); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			if dbInstance == db.Database(conns.pgTimescale) {
+				if err := conns.pgTimescale.ExecManualCompression(); err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+			}
+
+			// b.Run calls the function once per round of b.N, so the stream is
+			// created outside of it. Every round continues after the new hours
+			// of the previous one instead of writing them again as updates.
+			stream := db.NewCorrectionStream(NUM_OBJECTS, window, CORRECTION_RATIO, 1)
+
+			name := fmt.Sprintf("%v-upsert-%v-rows-%v-pct-corrections-within-%vh", dbInstance.GetName(), BATCH_SIZE, int(CORRECTION_RATIO*100), window.Hours())
+			b.Run(name, func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					batch := stream.NextBatch(BATCH_SIZE)
+					b.StartTimer()
+
+					if err := dbInstance.UpsertBulk(batch); err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Hourly and daily aggregations over a range of the data. Timescale reads its
+// continuous aggregates, while the other databases aggregate the raw rows.
+func BenchmarkAggregates(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	pgTimescale := conns.pgTimescale
+	opts := pgTimescale.Options()
+	opts.ContinuousAggregates = true
+	pgTimescale.SetOptions(opts)
+
+	NUM_OBJECTS := 100_000
+	UPDATE_LIMIT := 4_000
+	AGGREGATE_DAYS := 365
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+	fakeUpdateChunk := fake[:UPDATE_LIMIT]
+
+	from := db.BaseTime
+	to := from.Add(time.Duration(NUM_OBJECTS) * time.Hour)
+
+	dbs := conns.All()
+
+	for _, dbInstance := range dbs {
+		if err := dbInstance.Setup(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	now := time.Now()
+	if err := pgTimescale.RefreshContinuousAggregates(from, to); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	b.Logf(" * initial refresh of the continuous aggregates of %v for %v rows: %v", pgTimescale.GetName(), NUM_OBJECTS, time.Since(now))
+
+	b.Run(fmt.Sprintf("%v-refresh-caggs-after-upsert-bulk-%v-rows", pgTimescale.GetName(), UPDATE_LIMIT), func(b *testing.B) {
+		refreshTo := fakeUpdateChunk[len(fakeUpdateChunk)-1].StartTime.Add(time.Hour)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			if err := pgTimescale.UpsertBulk(fakeUpdateChunk); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+			b.StartTimer()
+
+			if err := pgTimescale.RefreshContinuousAggregates(from, refreshTo); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		}
+	})
+
+	aggregateTo := from.Add(time.Duration(AGGREGATE_DAYS) * 24 * time.Hour)
+
+	for _, bucket := range []db.Bucket{db.BUCKET_HOUR, db.BUCKET_DAY} {
+		for _, dbInstance := range dbs {
+			b.Run(fmt.Sprintf("%v-aggregate-%v-%v-days", dbInstance.GetName(), bucket, AGGREGATE_DAYS), func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					rows, err := dbInstance.GetAggregated(bucket, from, aggregateTo)
+					if err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+					if len(rows) == 0 {
+						b.Fatalf("Expected aggregated rows, got none")
+					}
+				}
+			})
+		}
+	}
+}
+
+// The latest value of every area, on a dataset with many series.
+func BenchmarkLatestPerArea(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	NUM_AREAS := 500
+	HOURS_PER_AREA := 200
+	fake := db.GenerateFakeSeries(NUM_AREAS, HOURS_PER_AREA)
+
+	dbs := conns.All()
+
+	for _, dbInstance := range dbs {
+		if err := dbInstance.Setup(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	if err := conns.pgTimescale.ExecManualCompression(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	for _, dbInstance := range dbs {
+		b.Run(fmt.Sprintf("%v-latest-per-area-%v-areas-%v-rows", dbInstance.GetName(), NUM_AREAS, len(fake)), func(b *testing.B) {
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				docs, err := dbInstance.GetOrderedWithLimit(UPDATE_AND_READ_LIMIT)
+				docs, err := dbInstance.GetLatestPerArea()
 				if err != nil {
 					b.Fatalf("Error: %v", err)
 				}
-				if len(docs) != UPDATE_AND_READ_LIMIT {
-					b.Fatalf("Expected %v docs, got %v", UPDATE_AND_READ_LIMIT, len(docs))
+				if len(docs) != NUM_AREAS {
+					b.Fatalf("Expected %v docs, got %v", NUM_AREAS, len(docs))
 				}
 			}
 		})
 	}
+}
 
-	// sleepTime := 30 * time.Second
-	// b.Logf("sleeping for %v sec to get the correct mongodb collection storage size\n", sleepTime.Seconds())
-	// time.Sleep(sleepTime)
+// Full table scans, once streamed row by row and once materialized into a
+// slice, to compare the peak heap usage next to the speed.
+func BenchmarkFullScan(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	NUM_OBJECTS := 1_000_000
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+
+	dbs := conns.All()
 
-	b.Logf(" * storage size for %v rows", NUM_OBJECTS)
 	for _, dbInstance := range dbs {
-		size, err := dbInstance.TableSizeInKB()
-		if err != nil {
+		if err := dbInstance.Setup(); err != nil {
 			b.Fatalf("Error: %v", err)
 		}
 
-		b.Logf("	- %v: %v KB\n", dbInstance.GetName(), size)
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	// Only the loaded tables should be on the heap when the scans start.
+	fake = nil
+
+	for _, dbInstance := range dbs {
+		b.Run(fmt.Sprintf("%v-stream-all-%v-rows", dbInstance.GetName(), NUM_OBJECTS), func(b *testing.B) {
+			heap := startPeakHeap()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				count := 0
+				for _, err := range dbInstance.StreamAll() {
+					if err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+
+					count++
+				}
+
+				if count != NUM_OBJECTS {
+					b.Fatalf("Expected %v docs, got %v", NUM_OBJECTS, count)
+				}
+			}
+
+			b.ReportMetric(heap.stop(), "peak-heap-MB")
+		})
+
+		b.Run(fmt.Sprintf("%v-get-all-%v-rows", dbInstance.GetName(), NUM_OBJECTS), func(b *testing.B) {
+			heap := startPeakHeap()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				docs, err := dbInstance.GetOrderedWithLimit(NUM_OBJECTS)
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				// The slice is only live until the next iteration, so it is
+				// measured after a GC as well, outside of the timing.
+				b.StopTimer()
+				heap.sampleAfterGC()
+				b.StartTimer()
+
+				if len(docs) != NUM_OBJECTS {
+					b.Fatalf("Expected %v docs, got %v", NUM_OBJECTS, len(docs))
+				}
+			}
+
+			b.ReportMetric(heap.stop(), "peak-heap-MB")
+		})
+	}
+}
+
+// HEAP_SAMPLE_INTERVAL is how often peakHeap reads the live heap.
+const HEAP_SAMPLE_INTERVAL = 10 * time.Millisecond
+
+// peakHeap keeps track of the largest live heap above the live heap at the
+// time it was started. The live heap is the heap which the last GC marked as
+// reachable, so unlike HeapAlloc it doesn't count the garbage which wasn't
+// collected yet. It is read with runtime/metrics from a separate goroutine, as
+// runtime.ReadMemStats would stop the world during the timed loops.
+type peakHeap struct {
+	base uint64
+	mu   sync.Mutex
+	peak uint64
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func startPeakHeap() *peakHeap {
+	runtime.GC()
+
+	h := &peakHeap{base: liveHeapBytes(), done: make(chan struct{})}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ticker := time.NewTicker(HEAP_SAMPLE_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.done:
+				return
+			case <-ticker.C:
+				h.sample()
+			}
+		}
+	}()
+
+	return h
+}
+
+func (h *peakHeap) sample() {
+	live := liveHeapBytes()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if live > h.base {
+		h.peak = max(h.peak, live-h.base)
+	}
+}
+
+// sampleAfterGC runs a GC first, so the live heap includes everything which is
+// still reachable. It stops the world, so it has to be called outside of the
+// timing.
+func (h *peakHeap) sampleAfterGC() {
+	runtime.GC()
+	h.sample()
+}
+
+// stop stops the sampling and returns the peak in MB.
+func (h *peakHeap) stop() float64 {
+	close(h.done)
+	h.wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return float64(h.peak) / 1024 / 1024
+}
+
+func liveHeapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/heap/live:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// Walks the whole table page by page, once with a keyset cursor and once with
+// OFFSET, which has to skip all of the previous pages for every page.
+func BenchmarkPagination(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	NUM_OBJECTS := 100_000
+	PAGE_SIZE := 1_000
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+
+	dbs := conns.All()
+
+	for _, dbInstance := range dbs {
+		if err := dbInstance.Setup(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	if err := conns.pgTimescale.ExecManualCompression(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	for _, dbInstance := range dbs {
+		b.Run(fmt.Sprintf("%v-page-keyset-%v-rows-%v-per-page", dbInstance.GetName(), NUM_OBJECTS, PAGE_SIZE), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				count := 0
+				var cursor *db.Cursor
+				for {
+					docs, err := dbInstance.GetPageBefore(cursor, PAGE_SIZE)
+					if err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+
+					count += len(docs)
+					if len(docs) < PAGE_SIZE {
+						break
+					}
+					cursor = docs[len(docs)-1].Cursor()
+				}
+
+				if count != NUM_OBJECTS {
+					b.Fatalf("Expected %v docs, got %v", NUM_OBJECTS, count)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v-page-offset-%v-rows-%v-per-page", dbInstance.GetName(), NUM_OBJECTS, PAGE_SIZE), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				count := 0
+				for {
+					docs, err := dbInstance.GetPageOffset(count, PAGE_SIZE)
+					if err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+
+					count += len(docs)
+					if len(docs) < PAGE_SIZE {
+						break
+					}
+				}
+
+				if count != NUM_OBJECTS {
+					b.Fatalf("Expected %v docs, got %v", NUM_OBJECTS, count)
+				}
+			}
+		})
+	}
+}
+
+// Native postgres with and without declarative partitioning, next to timescale.
+// Every mode is loaded into a fresh table one after another.
+func BenchmarkPostgresModes(b *testing.B) {
+	pgNative, err := db.NewPostgresDB("pg-ntv", "localhost", db.PORT_POSTGRES, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, false)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer pgNative.Close()
+
+	pgTimescale, err := db.NewPostgresDB("pg-tsc", "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer pgTimescale.Close()
+
+	UPDATE_AND_READ_LIMIT := 4_000
+	PARTITION_WIDTHS := []time.Duration{30 * 24 * time.Hour, 365 * 24 * time.Hour}
+	fake := benchmarkData(b, 100_000, UPDATE_AND_READ_LIMIT)
+
+	benchmarkScenario(b, pgNative, pgNative.GetName(), fake, UPDATE_AND_READ_LIMIT, nil)
+
+	for _, width := range PARTITION_WIDTHS {
+		opts := pgNative.Options()
+		opts.PartitionWidth = width
+		opts.PartitionFrom = fake[0].StartTime
+		opts.PartitionTo = fake[len(fake)-1].StartTime.Add(time.Hour)
+		pgNative.SetOptions(opts)
+
+		name := fmt.Sprintf("pg-part-%vd", width.Hours()/24)
+		benchmarkScenario(b, pgNative, name, fake, UPDATE_AND_READ_LIMIT, nil)
+	}
+
+	benchmarkScenario(b, pgTimescale, pgTimescale.GetName(), fake, UPDATE_AND_READ_LIMIT, pgTimescale.ExecManualCompression)
+}
+
+// Native postgres with a single additional index strategy on top of the primary
+// key, to compare the effect on writes, reads and the table size. The reads of
+// a single area show the indexes on area, their plans are logged.
+func BenchmarkPostgresIndexes(b *testing.B) {
+	pgNative, err := db.NewPostgresDB("pg-ntv", "localhost", db.PORT_POSTGRES, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, false)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer pgNative.Close()
+
+	NUM_AREAS := 100
+	HOURS_PER_AREA := 1_000
+	UPDATE_AND_READ_LIMIT := 4_000
+	AREA_RANGE_DAYS := 7
+	INDEXES := []db.PostgresIndex{"", db.INDEX_BRIN_START_TIME, db.INDEX_START_TIME_DESC, db.INDEX_AREA_START_TIME, db.INDEX_COVERING_AREA_VALUE}
+	// Several areas, so the indexes on area are selective.
+	fake := db.GenerateFakeSeries(NUM_AREAS, HOURS_PER_AREA)
+
+	for _, idx := range INDEXES {
+		opts := pgNative.Options()
+		opts.Indexes = nil
+		name := pgNative.GetName() + "-pkey-only"
+		if idx != "" {
+			opts.Indexes = []db.PostgresIndex{idx}
+			name = fmt.Sprintf("%v-%v", pgNative.GetName(), idx)
+		}
+		pgNative.SetOptions(opts)
+
+		benchmarkScenario(b, pgNative, name, fake, UPDATE_AND_READ_LIMIT, nil)
+
+		// The reads of a single area, which only the indexes on area help.
+		rnd := rand.New(rand.NewSource(1))
+		from := fake[len(fake)/2].StartTime
+		to := from.Add(time.Duration(AREA_RANGE_DAYS) * 24 * time.Hour)
+
+		b.Run(fmt.Sprintf("%v-get-area-range-%v-days", name, AREA_RANGE_DAYS), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				area := fake[rnd.Intn(NUM_AREAS)].Area
+				docs, err := pgNative.GetAreaRange(area, from, to)
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+				if len(docs) != AREA_RANGE_DAYS*24 {
+					b.Fatalf("Expected %v docs, got %v", AREA_RANGE_DAYS*24, len(docs))
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v-get-latest-value-of-area", name), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := pgNative.GetLatestValue(fake[rnd.Intn(NUM_AREAS)].Area); err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+			}
+		})
+
+		rangePlan, latestPlan, err := pgNative.ExplainAreaReads(fake[0].Area, from, to)
+		if err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+		b.Logf(" * plan of the area range of %v:\n%v", name, rangePlan)
+		b.Logf(" * plan of the latest value of an area of %v:\n%v", name, latestPlan)
+	}
+}
+
+// Plain mongodb collection next to time-series collections with different
+// granularities.
+func BenchmarkMongoModes(b *testing.B) {
+	mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer mongo.Close()
+
+	UPDATE_AND_READ_LIMIT := 4_000
+	GRANULARITIES := []string{"hours", "minutes"}
+	fake := benchmarkData(b, 100_000, UPDATE_AND_READ_LIMIT)
+
+	benchmarkScenario(b, mongo, mongo.GetName(), fake, UPDATE_AND_READ_LIMIT, nil)
+
+	for _, granularity := range GRANULARITIES {
+		mongo.SetOptions(db.MongoOptions{TimeSeries: true, Granularity: granularity})
+		benchmarkScenario(b, mongo, fmt.Sprintf("%v-ts-%v", mongo.GetName(), granularity), fake, UPDATE_AND_READ_LIMIT, nil)
+
+		if mongo.TimeSeriesUpsertFallback() {
+			b.Logf(" * %v-ts-%v: the server rejects time-series upserts, the upserts were a delete + insert", mongo.GetName(), granularity)
+		}
+	}
+}
+
+// The write strategies of mongodb, each loaded into a fresh collection. The
+// initial load with InsertMany is benchmarked on its own, as it can not upsert.
+func BenchmarkMongoWriteStrategies(b *testing.B) {
+	mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer mongo.Close()
+
+	NUM_OBJECTS := 100_000
+	UPDATE_AND_READ_LIMIT := 4_000
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+
+	strategies := []struct {
+		name string
+		opts db.MongoOptions
+	}{
+		{"ordered-set-all", db.MongoOptions{WriteStrategy: db.MONGO_WRITE_SET_ALL}},
+		{"unordered-set-all", db.MongoOptions{WriteStrategy: db.MONGO_WRITE_SET_ALL, UnorderedBulk: true}},
+		{"ordered-replace", db.MongoOptions{WriteStrategy: db.MONGO_WRITE_REPLACE}},
+		{"ordered-set-on-insert", db.MongoOptions{WriteStrategy: db.MONGO_WRITE_SET_ON_INSERT}},
+		{"unordered-set-on-insert", db.MongoOptions{WriteStrategy: db.MONGO_WRITE_SET_ON_INSERT, UnorderedBulk: true}},
+	}
+
+	for _, strategy := range strategies {
+		mongo.SetOptions(strategy.opts)
+		benchmarkScenario(b, mongo, fmt.Sprintf("%v-%v", mongo.GetName(), strategy.name), fake, UPDATE_AND_READ_LIMIT, nil)
+	}
+
+	for _, unordered := range []bool{false, true} {
+		mongo.SetOptions(db.MongoOptions{UnorderedBulk: unordered})
+		if err := mongo.Setup(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		name := "ordered"
+		if unordered {
+			name = "unordered"
+		}
+
+		b.Run(fmt.Sprintf("%v-%v-insert-many-%v-rows", mongo.GetName(), name, NUM_OBJECTS), func(b *testing.B) {
+			b.ResetTimer()
+			if err := mongo.InsertMany(fake); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		})
+	}
+}
+
+// The parquet archive tier with daily and monthly files, next to the live
+// duckdb table which serves the same reads.
+func BenchmarkParquet(b *testing.B) {
+	duckDb, err := db.NewDuckDB("duckdb", "./duckdb.db")
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer duckDb.Close()
+
+	NUM_OBJECTS := 100_000
+	UPDATE_AND_READ_LIMIT := 4_000
+	PARTITIONS := []db.ParquetPartition{db.PARQUET_PARTITION_DAY, db.PARQUET_PARTITION_MONTH}
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+
+	benchmarkScenario(b, duckDb, duckDb.GetName(), fake, UPDATE_AND_READ_LIMIT, nil)
+
+	for _, partition := range PARTITIONS {
+		parquet, err := db.NewParquetDB(fmt.Sprintf("parquet-%v", partition), "./parquet", partition)
+		if err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		benchmarkScenario(b, parquet, parquet.GetName(), fake, UPDATE_AND_READ_LIMIT, nil)
+		parquet.Close()
+	}
+}
+
+// Every database with each of the value types, loaded with values which look
+// like the type, to compare the write and read speed and the storage size. The
+// string values can not be aggregated, so the aggregates are not benchmarked.
+func BenchmarkValueTypes(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	pgTimescale := conns.pgTimescale
+
+	NUM_OBJECTS := 100_000
+	UPDATE_AND_READ_LIMIT := 4_000
+	dbs := conns.All()
+
+	for _, valueType := range db.VALUE_TYPES {
+		fake := db.GenerateFakeDataOfType(NUM_OBJECTS, valueType)
+
+		loadDatabases(b, dbs, db.Schema{ValueType: valueType}, string(valueType), fake)
+
+		if err := pgTimescale.ExecManualCompression(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		for _, dbInstance := range dbs {
+			b.Run(fmt.Sprintf("%v-%v-get-%v", dbInstance.GetName(), valueType, UPDATE_AND_READ_LIMIT), func(b *testing.B) {
+				benchmarkGetOrdered(b, dbInstance, UPDATE_AND_READ_LIMIT)
+			})
+		}
+
+		b.Logf(" * storage size for %v values, %v rows", valueType, NUM_OBJECTS)
+		logStorageSizes(b, dbs)
+
+		logMongoStorageStats(b, conns.mongo)
+		logCompressionStats(b, pgTimescale)
+	}
+}
+
+// Devices with 10 and 50 measurements per timestamp, stored once as a wide row
+// per timestamp and once as a narrow row per measurement (with the field in the
+// area). Both layouts hold the same measurements and the reads return the
+// measurements of the same timestamps.
+func BenchmarkWideSchema(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	pgTimescale := conns.pgTimescale
+
+	NUM_TIMESTAMPS := 10_000
+	READ_LIMIT := 1_000
+	FIELDS := []int{10, 50}
+	dbs := conns.All()
+
+	for _, fields := range FIELDS {
+		schema := db.Schema{Fields: fields}
+		wide := db.GenerateFakeDataForSchema(NUM_TIMESTAMPS, schema)
+
+		layouts := []struct {
+			name   string
+			schema db.Schema
+			rows   []db.DataObject
+			limit  int
+		}{
+			{fmt.Sprintf("narrow-%v", fields), db.DefaultSchema, narrowRows(wide), READ_LIMIT * fields},
+			{fmt.Sprintf("wide-%v", fields), schema, wide, READ_LIMIT},
+		}
+
+		for _, layout := range layouts {
+			loadDatabases(b, dbs, layout.schema, layout.name, layout.rows)
+
+			if err := pgTimescale.ExecManualCompression(); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			for _, dbInstance := range dbs {
+				b.Run(fmt.Sprintf("%v-%v-get-%v-timestamps", dbInstance.GetName(), layout.name, READ_LIMIT), func(b *testing.B) {
+					benchmarkGetOrdered(b, dbInstance, layout.limit)
+				})
+			}
+
+			b.Logf(" * storage size for %v, %v measurements", layout.name, NUM_TIMESTAMPS*fields)
+			logStorageSizes(b, dbs)
+		}
+	}
+}
+
+// Filtering by the labels of the series, from a single device (1% of the rows)
+// to a whole region (25% of the rows). Postgres and timescale use a GIN index,
+// mongodb a wildcard index and mysql the functional indexes of the default
+// MySQLOptions.IndexedLabels (the keys of FakeLabels), while duckdb scans the
+// JSON.
+func BenchmarkLabels(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	pgTimescale := conns.pgTimescale
+
+	NUM_AREAS := 100
+	HOURS_PER_AREA := 1_000
+	READ_LIMIT := 1_000
+	fake := db.GenerateFakeLabeledSeries(NUM_AREAS, HOURS_PER_AREA)
+	dbs := conns.All()
+
+	filters := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{"device", map[string]string{"device": "device-0042"}},
+		{"region-kind", map[string]string{"region": db.FAKE_LABEL_REGIONS[0], "kind": db.FAKE_LABEL_KINDS[0]}},
+		{"region", map[string]string{"region": db.FAKE_LABEL_REGIONS[0]}},
+	}
+
+	loadDatabases(b, dbs, db.Schema{Labels: true}, "labels", fake)
+
+	if err := pgTimescale.ExecManualCompression(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	for _, filter := range filters {
+		for _, dbInstance := range dbs {
+			b.Run(fmt.Sprintf("%v-get-by-labels-%v-limit-%v", dbInstance.GetName(), filter.name, READ_LIMIT), func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					docs, err := dbInstance.GetByLabels(filter.labels, READ_LIMIT)
+					if err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+					if len(docs) == 0 {
+						b.Fatalf("Expected docs for %v", filter.labels)
+					}
+				}
+			})
+		}
+	}
+
+	b.Logf(" * storage size for %v rows with labels", len(fake))
+	logStorageSizes(b, dbs)
+}
+
+// The same series stored denormalized in data_objects and normalized in the
+// series and data_points tables, which are joined back together by the reads.
+// mongodb has no normalized schema.
+func BenchmarkNormalizedSchema(b *testing.B) {
+	conns := connectDatabases(b)
+	defer conns.Close()
+
+	pgTimescale := conns.pgTimescale
+
+	NUM_AREAS := 100
+	HOURS_PER_AREA := 1_000
+	UPDATE_AND_READ_LIMIT := 1_000
+	fake := db.GenerateFakeSeries(NUM_AREAS, HOURS_PER_AREA)
+	dbs := []db.Database{conns.mysql, conns.pgNative, conns.pgTimescale, conns.duckDb}
+
+	layouts := []struct {
+		name   string
+		schema db.Schema
+	}{
+		{"denormalized", db.DefaultSchema},
+		{"normalized", db.Schema{Normalized: true}},
+	}
+
+	// A source change updates a single row in the denormalized layout, but
+	// the whole series in the normalized one, so the corrections keep the
+	// source to compare the same upserts.
+	b.Logf(" * the corrections only change the values, a source change would update the whole series in the normalized layout")
+
+	for _, layout := range layouts {
+		loadDatabases(b, dbs, layout.schema, layout.name, fake)
+
+		for _, dbInstance := range dbs {
+			revised := slices.Clone(fake[len(fake)-UPDATE_AND_READ_LIMIT:])
+			for i := range revised {
+				revised[i].Value = rand.Float64()
+			}
+
+			b.Run(fmt.Sprintf("%v-%v-upsert-bulk-%v-rows", dbInstance.GetName(), layout.name, len(revised)), func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := dbInstance.UpsertBulk(revised); err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+				}
+			})
+		}
+
+		if err := pgTimescale.ExecManualCompression(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		for _, dbInstance := range dbs {
+			b.Run(fmt.Sprintf("%v-%v-get-%v", dbInstance.GetName(), layout.name, UPDATE_AND_READ_LIMIT), func(b *testing.B) {
+				benchmarkGetOrdered(b, dbInstance, UPDATE_AND_READ_LIMIT)
+			})
+
+			b.Run(fmt.Sprintf("%v-%v-get-one-random", dbInstance.GetName(), layout.name), func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					want := fake[rand.Intn(len(fake))]
+					if _, err := dbInstance.GetOne(want.StartTime, want.Interval, want.Area); err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+				}
+			})
+
+			b.Run(fmt.Sprintf("%v-%v-latest-per-area", dbInstance.GetName(), layout.name), func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					docs, err := dbInstance.GetLatestPerArea()
+					if err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+					if len(docs) != NUM_AREAS {
+						b.Fatalf("Expected %v docs, got %v", NUM_AREAS, len(docs))
+					}
+				}
+			})
+
+			b.Run(fmt.Sprintf("%v-%v-aggregate-%v", dbInstance.GetName(), layout.name, db.BUCKET_DAY), func(b *testing.B) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := dbInstance.GetAggregated(db.BUCKET_DAY, fake[0].StartTime, fake[len(fake)-1].StartTime); err != nil {
+						b.Fatalf("Error: %v", err)
+					}
+				}
+			})
+		}
+
+		b.Logf(" * storage size for %v, %v rows", layout.name, len(fake))
+		logStorageSizes(b, dbs)
+	}
+}
+
+// narrowRows splits every wide row into a row per value, with the name of the
+// field appended to the area.
+func narrowRows(wide []db.DataObject) []db.DataObject {
+	var rows []db.DataObject
+	for _, row := range wide {
+		values := append([]any{row.Value}, row.Values...)
+		for i, value := range values {
+			narrow := row
+			narrow.Area = fmt.Sprintf("%v-value_%v", row.Area, i)
+			narrow.Value = value
+			narrow.Values = nil
+			rows = append(rows, narrow)
+		}
+	}
+
+	return rows
+}
+
+// benchmarkData generates numObjects rows, unless TIMESERIES_CSV points to a
+// CSV file with real data, which is imported instead (every row of the file).
+// The columns are mapped with TIMESERIES_CSV_COLUMNS, e.g.
+// "start_time=ts,value=price,interval=", and the timestamps are parsed with
+// TIMESERIES_CSV_TIME_LAYOUT (RFC3339 by default, see db.CSVMapping). The
+// benchmarks slice up to minRows rows out of the data, so a file with fewer
+// rows fails the benchmark.
+func benchmarkData(b *testing.B, numObjects, minRows int) []db.DataObject {
+	path := os.Getenv("TIMESERIES_CSV")
+	if path == "" {
+		return db.GenerateFakeData(numObjects)
+	}
+
+	mapping := db.DefaultCSVMapping()
+	if layout := os.Getenv("TIMESERIES_CSV_TIME_LAYOUT"); layout != "" {
+		mapping.TimeLayout = layout
+	}
+
+	if columns := os.Getenv("TIMESERIES_CSV_COLUMNS"); columns != "" {
+		for _, pair := range strings.Split(columns, ",") {
+			field, column, _ := strings.Cut(pair, "=")
+			if err := mapping.SetColumn(strings.TrimSpace(field), strings.TrimSpace(column)); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		}
+	}
+
+	rows, err := db.ImportCSVFile(path, mapping)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	if len(rows) < minRows {
+		b.Fatalf("%v has %v rows, the benchmark needs at least %v", path, len(rows), minRows)
+	}
+
+	b.Logf(" * imported %v rows from %v", len(rows), path)
+	return rows
+}
+
+// benchmarkScenario sets up the database, loads the data, calls afterLoad (if
+// not nil) and benchmarks the upserts and reads on the loaded table. The storage
+// size is logged at the end.
+func benchmarkScenario(b *testing.B, dbInstance db.Database, name string, fake []db.DataObject, limit int, afterLoad func() error) {
+	if err := dbInstance.Setup(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	b.Run(fmt.Sprintf("%v-insert-bulk-%v-rows", name, len(fake)), func(b *testing.B) {
+		b.ResetTimer()
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	})
+
+	if afterLoad != nil {
+		if err := afterLoad(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	fakeUpdateChunk := fake[:limit]
+
+	b.Run(fmt.Sprintf("%v-upsert-single-%v-rows", name, limit), func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := dbInstance.UpsertSingle(fakeUpdateChunk); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		}
+	})
+
+	b.Run(fmt.Sprintf("%v-upsert-bulk-%v-rows", name, limit), func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := dbInstance.UpsertBulk(fakeUpdateChunk); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		}
+	})
+
+	b.Run(fmt.Sprintf("%v-get-%v", name, limit), func(b *testing.B) {
+		benchmarkGetOrdered(b, dbInstance, limit)
+	})
+
+	b.Run(fmt.Sprintf("%v-get-one-random", name), func(b *testing.B) {
+		benchmarkGetOne(b, dbInstance, fake)
+	})
+
+	from := fake[0].StartTime
+	to := from.Add(365 * 24 * time.Hour)
+
+	b.Run(fmt.Sprintf("%v-aggregate-day-365-days", name), func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := dbInstance.GetAggregated(db.BUCKET_DAY, from, to); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		}
+	})
+
+	size, err := dbInstance.TableSizeInKB()
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	b.Logf(" * storage size for %v, %v rows: %v KB", name, len(fake), size)
+}
+
+// loadDatabases sets up every database with the schema and benchmarks the bulk
+// insert of the rows.
+func loadDatabases(b *testing.B, dbs []db.Database, schema db.Schema, name string, rows []db.DataObject) {
+	for _, dbInstance := range dbs {
+		if err := dbInstance.SetSchema(schema); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+		if err := dbInstance.Setup(); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		b.Run(fmt.Sprintf("%v-%v-insert-bulk-%v-rows", dbInstance.GetName(), name, len(rows)), func(b *testing.B) {
+			b.ResetTimer()
+			if err := dbInstance.UpsertBulk(rows); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		})
+	}
+}
+
+// benchmarkGetOrdered reads the newest limit rows of the loaded data.
+func benchmarkGetOrdered(b *testing.B, dbInstance db.Database, limit int) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		docs, err := dbInstance.GetOrderedWithLimit(limit)
+		if err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+		if len(docs) != limit {
+			b.Fatalf("Expected %v docs, got %v", limit, len(docs))
+		}
+	}
+}
+
+// logStorageSizes logs the storage size of every database, below the line
+// which describes the data.
+func logStorageSizes(b *testing.B, dbs []db.Database) {
+	for _, dbInstance := range dbs {
+		size, err := dbInstance.TableSizeInKB()
+		if err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		b.Logf("	- %v: %v KB\n", dbInstance.GetName(), size)
+	}
+}
+
+// benchmarkGetOne looks up random rows of the loaded data by their key.
+func benchmarkGetOne(b *testing.B, dbInstance db.Database, fake []db.DataObject) {
+	rnd := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		want := fake[rnd.Intn(len(fake))]
+
+		doc, err := dbInstance.GetOne(want.StartTime, want.Interval, want.Area)
+		if err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+		if !doc.StartTime.Equal(want.StartTime) {
+			b.Fatalf("Expected start_time %v, got %v", want.StartTime, doc.StartTime)
+		}
+	}
+}
+
+func logMongoStorageStats(b *testing.B, mongo *db.MongoDB) {
+	stats, err := mongo.StorageStats()
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	b.Logf(" * storage of %v: %v documents, data %v KB, storage %v KB, indexes %v KB, total %v KB",
+		mongo.GetName(), stats.Count, stats.DataSize/1024, stats.StorageSize/1024, stats.IndexSize/1024, stats.TotalSize/1024)
+	if !stats.Settled {
+		b.Logf("	- warning: the sizes did not settle within %v, these are the last sizes read", db.MONGO_STATS_TIMEOUT)
+	}
+}
+
+func reportOpenLoop(b *testing.B, res bench.OpenLoopResult) {
+	b.Logf(" * %v", res)
+	if res.FirstErr != nil {
+		b.Logf("	- first error: %v", res.FirstErr)
+	}
+
+	b.ReportMetric(float64(res.Latency.P99.Microseconds())/1000, "p99-ms")
+	b.ReportMetric(res.RowsPerSec, "rows/s")
+}
+
+type databases struct {
+	mongo       *db.MongoDB
+	pgNative    *db.PostgresDB
+	pgTimescale *db.PostgresDB
+	mysql       *db.MySQLDB
+	duckDb      *db.DuckDB
+}
+
+func connectDatabases(b *testing.B) *databases {
+	mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	pgNative, err := db.NewPostgresDB("pg-ntv", "localhost", db.PORT_POSTGRES, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, false)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	pgTimescale, err := db.NewPostgresDB("pg-tsc", "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	dbMysql, err := db.NewMySQLDB("mysql", "localhost", db.PORT_MYSQL, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	duckDb, err := db.NewDuckDB("duckdb", "./duckdb.db")
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	return &databases{
+		mongo:       mongo,
+		pgNative:    pgNative,
+		pgTimescale: pgTimescale,
+		mysql:       dbMysql,
+		duckDb:      duckDb,
+	}
+}
+
+// All returns the databases in the order in which they are benchmarked.
+func (d *databases) All() []db.Database {
+	return []db.Database{d.mysql, d.mongo, d.pgNative, d.pgTimescale, d.duckDb}
+}
+
+func (d *databases) Close() {
+	for _, dbInstance := range d.All() {
+		dbInstance.Close()
 	}
 }
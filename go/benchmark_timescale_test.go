@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"timeseries-benchmark/bench"
+	"timeseries-benchmark/db"
+)
+
+// Upserts of rows which live in compressed chunks. BenchmarkTimeseries only
+// compresses after all of the writes, so it never shows the cost of modifying
+// compressed data. The chunks are recompressed before every iteration, outside
+// of the timer, so every iteration writes into fully compressed chunks.
+func BenchmarkTimescaleCompressedUpserts(b *testing.B) {
+	pgTimescale, err := db.NewPostgresDB("pg-tsc", "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer pgTimescale.Close()
+
+	NUM_OBJECTS := 100_000
+	UPDATE_LIMIT := 4_000
+	fake := db.GenerateFakeData(NUM_OBJECTS)
+	fakeUpdateChunk := fake[:UPDATE_LIMIT]
+
+	if err := pgTimescale.Setup(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	if err := pgTimescale.UpsertBulk(fake); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	logChunkStatuses(b, pgTimescale, "uncompressed")
+
+	b.Run(fmt.Sprintf("%v-uncompressed-upsert-single-%v-rows", pgTimescale.GetName(), UPDATE_LIMIT), func(b *testing.B) {
+		benchmarkUpsertSingleLatency(b, pgTimescale, fakeUpdateChunk, nil)
+	})
+
+	if err := pgTimescale.ExecManualCompression(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	logChunkStatuses(b, pgTimescale, "after compression")
+	logCompressionStats(b, pgTimescale)
+
+	b.Run(fmt.Sprintf("%v-compressed-upsert-single-%v-rows", pgTimescale.GetName(), UPDATE_LIMIT), func(b *testing.B) {
+		benchmarkUpsertSingleLatency(b, pgTimescale, fakeUpdateChunk, func() { recompress(b, pgTimescale) })
+	})
+
+	logChunkStatuses(b, pgTimescale, "after upsert-single into compressed chunks")
+
+	if err := pgTimescale.ExecManualCompression(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	logChunkStatuses(b, pgTimescale, "after recompression")
+	logCompressionStats(b, pgTimescale)
+
+	b.Run(fmt.Sprintf("%v-compressed-upsert-bulk-%v-rows", pgTimescale.GetName(), UPDATE_LIMIT), func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			recompress(b, pgTimescale)
+			if err := pgTimescale.UpsertBulk(fakeUpdateChunk); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+		}
+	})
+
+	logChunkStatuses(b, pgTimescale, "after upsert-bulk into compressed chunks")
+}
+
+// Every combination of chunk interval, compress_segmentby and compress_orderby
+// is loaded into a fresh hypertable. For each of them the size before and after
+// the compression is logged, and the reads and bulk upserts are benchmarked on
+// the compressed table.
+func BenchmarkTimescaleMatrix(b *testing.B) {
+	pgTimescale, err := db.NewPostgresDB("pg-tsc", "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer pgTimescale.Close()
+
+	UPDATE_AND_READ_LIMIT := 4_000
+	CHUNK_INTERVALS := []string{"7 days", "30 days", "60 days"}
+	SEGMENT_BY := []string{"", "area"}
+	ORDER_BY := []string{"", "start_time DESC"}
+	fake := benchmarkData(b, 100_000, UPDATE_AND_READ_LIMIT)
+	fakeUpdateChunk := fake[:UPDATE_AND_READ_LIMIT]
+
+	type sizes struct {
+		opts         db.PostgresOptions
+		load         time.Duration
+		before       int
+		after        int
+		compressTime time.Duration
+	}
+	var summary []sizes
+
+	for _, chunkInterval := range CHUNK_INTERVALS {
+		for _, segmentBy := range SEGMENT_BY {
+			for _, orderBy := range ORDER_BY {
+				opts := db.PostgresOptions{ChunkInterval: chunkInterval, CompressSegmentBy: segmentBy, CompressOrderBy: orderBy}
+				pgTimescale.SetOptions(opts)
+
+				if err := pgTimescale.Setup(); err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				now := time.Now()
+				if err := pgTimescale.UpsertBulk(fake); err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+				load := time.Since(now)
+
+				before, err := pgTimescale.TableSizeInKB()
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				now = time.Now()
+				if err := pgTimescale.ExecManualCompression(); err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+				compressTime := time.Since(now)
+
+				after, err := pgTimescale.TableSizeInKB()
+				if err != nil {
+					b.Fatalf("Error: %v", err)
+				}
+
+				summary = append(summary, sizes{opts, load, before, after, compressTime})
+				name := timescaleOptionsName(opts)
+
+				b.Run(fmt.Sprintf("%v-%v-get-%v", pgTimescale.GetName(), name, UPDATE_AND_READ_LIMIT), func(b *testing.B) {
+					benchmarkGetOrdered(b, pgTimescale, UPDATE_AND_READ_LIMIT)
+				})
+
+				b.Run(fmt.Sprintf("%v-%v-compressed-upsert-bulk-%v-rows", pgTimescale.GetName(), name, UPDATE_AND_READ_LIMIT), func(b *testing.B) {
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						recompress(b, pgTimescale)
+						if err := pgTimescale.UpsertBulk(fakeUpdateChunk); err != nil {
+							b.Fatalf("Error: %v", err)
+						}
+					}
+				})
+			}
+		}
+	}
+
+	b.Logf(" * storage size for %v rows", len(fake))
+	for _, s := range summary {
+		b.Logf("	- %v: load %v, compression %v, %v KB -> %v KB (%.1fx)",
+			timescaleOptionsName(s.opts), s.load.Round(time.Millisecond), s.compressTime.Round(time.Millisecond),
+			s.before, s.after, float64(s.before)/float64(max(s.after, 1)))
+	}
+}
+
+func timescaleOptionsName(opts db.PostgresOptions) string {
+	segmentBy, orderBy := opts.CompressSegmentBy, opts.CompressOrderBy
+	if segmentBy == "" {
+		segmentBy = "default"
+	}
+	if orderBy == "" {
+		orderBy = "default"
+	}
+
+	name := fmt.Sprintf("chunk-%v-segmentby-%v-orderby-%v", opts.ChunkInterval, segmentBy, orderBy)
+	return strings.ReplaceAll(name, " ", "-")
+}
+
+// benchmarkUpsertSingleLatency upserts the rows one at a time and reports the
+// latency percentiles of a single row on top of the ns/op of all rows. prepare
+// is called before every iteration if not nil, and is responsible for stopping
+// the timer itself.
+func benchmarkUpsertSingleLatency(b *testing.B, dbInstance db.Database, docs []db.DataObject, prepare func()) {
+	latencies := make([]time.Duration, 0, len(docs))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if prepare != nil {
+			prepare()
+		}
+		latencies = latencies[:0]
+		for j := range docs {
+			now := time.Now()
+			if err := dbInstance.UpsertSingle(docs[j : j+1]); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+			latencies = append(latencies, time.Since(now))
+		}
+	}
+	b.StopTimer()
+
+	summary := bench.Summarize(latencies)
+	b.ReportMetric(float64(summary.P50.Microseconds())/1000, "p50-ms")
+	b.ReportMetric(float64(summary.P99.Microseconds())/1000, "p99-ms")
+}
+
+// recompress compresses every chunk with the timer stopped and fails if a chunk
+// is left uncompressed or partially compressed, so the next write always starts
+// on fully compressed chunks.
+func recompress(b *testing.B, pgTimescale *db.PostgresDB) {
+	b.StopTimer()
+	defer b.StartTimer()
+
+	if err := pgTimescale.ExecManualCompression(); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	chunks, err := pgTimescale.ChunkStatuses()
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	for _, c := range chunks {
+		if !c.IsCompressed || c.IsPartiallyCompressed {
+			b.Fatalf("Chunk %v is not fully compressed before the upserts", c.Name)
+		}
+	}
+}
+
+func logChunkStatuses(b *testing.B, pgTimescale *db.PostgresDB, stage string) {
+	chunks, err := pgTimescale.ChunkStatuses()
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	var compressed, partial int
+	var totalBytes int64
+	for _, c := range chunks {
+		if c.IsCompressed {
+			compressed++
+		}
+		if c.IsPartiallyCompressed {
+			partial++
+		}
+		totalBytes += c.TotalBytes
+	}
+
+	b.Logf(" * chunks of %v %v: %v total, %v compressed, %v partially compressed, %v KB",
+		pgTimescale.GetName(), stage, len(chunks), compressed, partial, totalBytes/1024)
+}
+
+func logCompressionStats(b *testing.B, pgTimescale *db.PostgresDB) {
+	stats, err := pgTimescale.CompressionStats()
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	b.Logf(" * compression of %v: %v of %v chunks compressed, %v KB -> %v KB",
+		pgTimescale.GetName(), stats.CompressedChunks, stats.TotalChunks,
+		stats.BeforeCompressionBytes/1024, stats.AfterCompressionBytes/1024)
+
+	for _, c := range stats.Chunks {
+		b.Logf("	- %v: %v, %v KB -> %v KB", c.Name, c.CompressionStatus, c.BeforeCompressionBytes/1024, c.AfterCompressionBytes/1024)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"timeseries-benchmark/db"
+)
+
+func TestImportCSV(t *testing.T) {
+	input := `ts;zone;interval;price
+2024-01-01T00:00:00Z;LV;PT1H;10.5
+2024-01-01T00:15:00+02:00;EE;900000;-3
+`
+
+	mapping := db.DefaultCSVMapping()
+	mapping.Comma = ';'
+	for field, column := range map[string]string{"start_time": "ts", "area": "zone", "value": "price", "source": ""} {
+		if err := mapping.SetColumn(field, column); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+
+	rows, err := db.ImportCSV(strings.NewReader(input), mapping)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %v", len(rows))
+	}
+
+	want := []db.DataObject{
+		{StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Interval: 3600000, Area: "LV", Source: mapping.DefaultSource, Value: 10.5},
+		{StartTime: time.Date(2023, 12, 31, 22, 15, 0, 0, time.UTC), Interval: 900000, Area: "EE", Source: mapping.DefaultSource, Value: -3.0},
+	}
+	for i, got := range rows {
+		if !got.StartTime.Equal(want[i].StartTime) || got.StartTime.Location() != time.UTC ||
+			got.Interval != want[i].Interval || got.Area != want[i].Area || got.Source != want[i].Source || got.Value != want[i].Value {
+			t.Errorf("row %v: got %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if _, err := db.ImportCSV(strings.NewReader("ts;price\n2024-01-01;1\n"), mapping); err == nil {
+		t.Errorf("Expected an error for the missing columns")
+	}
+
+	// The values are parsed as the value type, without the precision loss of
+	// a float64.
+	mapping.ValueType = db.VALUE_TYPE_INT64
+	rows, err = db.ImportCSV(strings.NewReader("ts;zone;interval;price\n2024-01-01T00:00:00Z;LV;PT1H;9007199254740993\n"), mapping)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if rows[0].Value != int64(9007199254740993) {
+		t.Errorf("Expected the value 9007199254740993, got %v (%T)", rows[0].Value, rows[0].Value)
+	}
+	if _, err := db.ImportCSV(strings.NewReader("ts;zone;interval;price\n2024-01-01T00:00:00Z;LV;PT1H;10.5\n"), mapping); err == nil {
+		t.Errorf("Expected an error for a float value of an int64 mapping")
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"3600000", 3600000},
+		{"PT1H", 3600000},
+		{"PT15M", 900000},
+		{"PT0.5S", 500},
+		{"P1D", 86400000},
+		{"P1W", 604800000},
+		{"P1DT1H30M", 91800000},
+	}
+
+	for _, test := range tests {
+		got, err := db.ParseInterval(test.input)
+		if err != nil {
+			t.Errorf("%v: %v", test.input, err)
+		} else if got != test.want {
+			t.Errorf("%v: got %v, want %v", test.input, got, test.want)
+		}
+	}
+
+	for _, input := range []string{"", "P", "PT", "P1M", "P1Y", "PT1D", "1h"} {
+		if _, err := db.ParseInterval(input); err == nil {
+			t.Errorf("%v: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"timeseries-benchmark/db"
+)
+
+// The names of the databases which can be opened by the commands, the same
+// names which are used in the benchmarks.
+var DATABASE_NAMES = []string{"mongodb", "pg-ntv", "pg-tsc", "mysql", "duckdb", "parquet-day", "parquet-month"}
+
+// databaseLayout describes the existing table of a database. The commands
+// can't detect it, so it is passed with flags and has to match the options
+// with which the benchmarks created the table.
+type databaseLayout struct {
+	Schema db.Schema `json:"schema"`
+	// The rows are in a mongodb time-series collection.
+	MongoTimeSeries bool `json:"mongo_timeseries"`
+}
+
+// openDatabase connects to the database with the given name and applies the
+// layout. The tables are not set up, so the existing data can be read.
+func openDatabase(name string, layout databaseLayout) (db.Database, error) {
+	if layout.MongoTimeSeries && name != "mongodb" {
+		return nil, fmt.Errorf("%v has no time-series collection", name)
+	}
+
+	dbInstance, err := connectDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dbInstance.SetSchema(layout.Schema); err != nil {
+		dbInstance.Close()
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+
+	if mongo, ok := dbInstance.(*db.MongoDB); ok && layout.MongoTimeSeries {
+		opts := mongo.Options()
+		opts.TimeSeries = true
+		mongo.SetOptions(opts)
+	}
+
+	return dbInstance, nil
+}
+
+// checkSchema fails if the database can read the schema of its table and it
+// doesn't match the schema of the layout, as the table would be misread.
+func checkSchema(dbInstance db.Database, layout databaseLayout) error {
+	detector, ok := dbInstance.(db.SchemaDetector)
+	if !ok {
+		return nil
+	}
+
+	schema, err := detector.DetectSchema()
+	if err != nil {
+		return fmt.Errorf("%v: failed to read the schema: %v", dbInstance.GetName(), err)
+	}
+
+	if schema != layout.Schema {
+		return fmt.Errorf("%v: the table has the schema %+v, not %+v (see -value-type, -fields, -labels and -normalized)",
+			dbInstance.GetName(), schema, layout.Schema)
+	}
+
+	return nil
+}
+
+func connectDatabase(name string) (db.Database, error) {
+	switch name {
+	case "mongodb":
+		return db.NewMongoDB(name, "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+	case "pg-ntv":
+		return db.NewPostgresDB(name, "localhost", db.PORT_POSTGRES, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, false)
+	case "pg-tsc":
+		return db.NewPostgresDB(name, "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
+	case "mysql":
+		return db.NewMySQLDB(name, "localhost", db.PORT_MYSQL, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME)
+	case "duckdb":
+		return db.NewDuckDB(name, "./duckdb.db")
+	case "parquet-day":
+		return db.NewParquetDB(name, "./parquet", db.PARQUET_PARTITION_DAY)
+	case "parquet-month":
+		return db.NewParquetDB(name, "./parquet", db.PARQUET_PARTITION_MONTH)
+	default:
+		return nil, fmt.Errorf("unknown database: %v (one of %v)", name, strings.Join(DATABASE_NAMES, ", "))
+	}
+}
+
+// schemaFlags are the flags of the value columns of a db.Schema.
+type schemaFlags struct {
+	valueType *string
+	fields    *int
+	labels    *bool
+}
+
+func addSchemaFlags(flags *flag.FlagSet) schemaFlags {
+	valueTypes := make([]string, len(db.VALUE_TYPES))
+	for i, valueType := range db.VALUE_TYPES {
+		valueTypes[i] = string(valueType)
+	}
+
+	return schemaFlags{
+		valueType: flags.String("value-type", string(db.DefaultSchema.ValueType), fmt.Sprintf("type of the value columns (%v)", strings.Join(valueTypes, ", "))),
+		fields:    flags.Int("fields", db.DefaultSchema.Fields, "number of value columns of a row"),
+		labels:    flags.Bool("labels", false, "the rows have a labels column"),
+	}
+}
+
+// schema returns the schema of the flags, normalized is the layout of the
+// SQL databases.
+func (f schemaFlags) schema(normalized bool) db.Schema {
+	return db.Schema{ValueType: db.ValueType(*f.valueType), Fields: *f.fields, Labels: *f.labels, Normalized: normalized}
+}
@@ -0,0 +1,305 @@
+package db
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Time layouts for numeric timestamps, next to the layouts of time.Parse.
+	CSV_TIME_UNIX    = "unix"
+	CSV_TIME_UNIX_MS = "unix-ms"
+)
+
+// CSVMapping maps the header columns of a CSV file to the fields of a
+// DataObject. An empty column uses the default of the field instead, except
+// for start_time and value which are required. created_at and updated_at
+// default to the time of the import. The values are parsed as ValueType,
+// float64 if it is empty.
+type CSVMapping struct {
+	StartTime string
+	Interval  string
+	Area      string
+	Source    string
+	Value     string
+	CreatedAt string
+	UpdatedAt string
+
+	ValueType ValueType
+
+	// TimeLayout is the layout of the timestamps (time.Parse), CSV_TIME_UNIX
+	// or CSV_TIME_UNIX_MS. Timestamps without a zone are read as UTC.
+	TimeLayout string
+
+	DefaultInterval int64
+	DefaultArea     string
+	DefaultSource   string
+
+	Comma rune
+}
+
+// DefaultCSVMapping expects the header to use the column names of the tables.
+func DefaultCSVMapping() CSVMapping {
+	return CSVMapping{
+		StartTime:       "start_time",
+		Interval:        "interval",
+		Area:            "area",
+		Source:          "source",
+		Value:           "value",
+		TimeLayout:      time.RFC3339,
+		DefaultInterval: 3600000,
+		DefaultArea:     "lv",
+		DefaultSource:   "csv-import",
+		Comma:           ',',
+	}
+}
+
+// SetColumn maps the field (the column name of the tables) to the column of
+// the CSV file.
+func (m *CSVMapping) SetColumn(field, column string) error {
+	switch field {
+	case "start_time":
+		m.StartTime = column
+	case "interval":
+		m.Interval = column
+	case "area":
+		m.Area = column
+	case "source":
+		m.Source = column
+	case "value":
+		m.Value = column
+	case "created_at":
+		m.CreatedAt = column
+	case "updated_at":
+		m.UpdatedAt = column
+	default:
+		return fmt.Errorf("unknown field: %v", field)
+	}
+
+	return nil
+}
+
+func ImportCSVFile(path string, mapping CSVMapping) ([]DataObject, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ImportCSVFile: %v", err)
+	}
+	defer f.Close()
+
+	return ImportCSV(f, mapping)
+}
+
+// ImportCSV reads every row of the CSV into a DataObject. The first row has to
+// be the header.
+func ImportCSV(r io.Reader, mapping CSVMapping) ([]DataObject, error) {
+	if mapping.StartTime == "" || mapping.Value == "" {
+		return nil, errors.New("ImportCSV: the start_time and value columns are required")
+	}
+
+	valueType := mapping.ValueType
+	if valueType == "" {
+		valueType = DefaultSchema.ValueType
+	}
+	if err := valueType.validate(); err != nil {
+		return nil, fmt.Errorf("ImportCSV: %v", err)
+	}
+
+	reader := csv.NewReader(r)
+	if mapping.Comma != 0 {
+		reader.Comma = mapping.Comma
+	}
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("ImportCSV: header: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	var missing []string
+	index := func(column string) int {
+		if column == "" {
+			return -1
+		}
+		i, ok := columns[column]
+		if !ok {
+			missing = append(missing, column)
+		}
+		return i
+	}
+
+	startTimeIdx := index(mapping.StartTime)
+	intervalIdx := index(mapping.Interval)
+	areaIdx := index(mapping.Area)
+	sourceIdx := index(mapping.Source)
+	valueIdx := index(mapping.Value)
+	createdAtIdx := index(mapping.CreatedAt)
+	updatedAtIdx := index(mapping.UpdatedAt)
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("ImportCSV: columns %q are not in the header", missing)
+	}
+
+	now := time.Now().UTC()
+
+	var rows []DataObject
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ImportCSV: %v", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		obj := DataObject{
+			CreatedAt: now,
+			UpdatedAt: now,
+			Interval:  mapping.DefaultInterval,
+			Area:      mapping.DefaultArea,
+			Source:    mapping.DefaultSource,
+		}
+
+		if obj.StartTime, err = parseCSVTime(record[startTimeIdx], mapping.TimeLayout); err != nil {
+			return nil, fmt.Errorf("ImportCSV: line %v: start_time: %v", line, err)
+		}
+
+		if obj.Value, err = valueType.parse(strings.TrimSpace(record[valueIdx])); err != nil {
+			return nil, fmt.Errorf("ImportCSV: line %v: value: %v", line, err)
+		}
+
+		if intervalIdx >= 0 {
+			if obj.Interval, err = ParseInterval(record[intervalIdx]); err != nil {
+				return nil, fmt.Errorf("ImportCSV: line %v: interval: %v", line, err)
+			}
+		}
+
+		if areaIdx >= 0 {
+			obj.Area = strings.TrimSpace(record[areaIdx])
+		}
+
+		if sourceIdx >= 0 {
+			obj.Source = strings.TrimSpace(record[sourceIdx])
+		}
+
+		if createdAtIdx >= 0 {
+			if obj.CreatedAt, err = parseCSVTime(record[createdAtIdx], mapping.TimeLayout); err != nil {
+				return nil, fmt.Errorf("ImportCSV: line %v: created_at: %v", line, err)
+			}
+		}
+
+		if updatedAtIdx >= 0 {
+			if obj.UpdatedAt, err = parseCSVTime(record[updatedAtIdx], mapping.TimeLayout); err != nil {
+				return nil, fmt.Errorf("ImportCSV: line %v: updated_at: %v", line, err)
+			}
+		}
+
+		rows = append(rows, obj)
+	}
+
+	return rows, nil
+}
+
+func parseCSVTime(s, layout string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	switch layout {
+	case CSV_TIME_UNIX, CSV_TIME_UNIX_MS:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if layout == CSV_TIME_UNIX {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.UnixMilli(n).UTC(), nil
+	case "":
+		layout = time.RFC3339
+	}
+
+	t, err := time.Parse(layout, s)
+	return t.UTC(), err
+}
+
+// ParseInterval parses an interval in milliseconds ("3600000") or as an
+// ISO-8601 duration ("PT1H", "P1D", "PT15M", "P1W"). Years and months are
+// rejected, as they don't have a fixed length.
+func ParseInterval(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty interval")
+	}
+
+	if s[0] != 'P' && s[0] != 'p' {
+		return strconv.ParseInt(s, 10, 64)
+	}
+
+	d, err := parseISODuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.Milliseconds(), nil
+}
+
+func parseISODuration(s string) (time.Duration, error) {
+	rest := strings.ToUpper(s[1:])
+	if rest == "" || rest == "T" {
+		return 0, fmt.Errorf("invalid duration: %v", s)
+	}
+
+	var total time.Duration
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' {
+			if inTime {
+				return 0, fmt.Errorf("invalid duration: %v", s)
+			}
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+
+		end := strings.IndexAny(rest, "YMWDHS")
+		if end <= 0 {
+			return 0, fmt.Errorf("invalid duration: %v", s)
+		}
+
+		n, err := strconv.ParseFloat(strings.Replace(rest[:end], ",", ".", 1), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %v", s)
+		}
+
+		var unit time.Duration
+		switch {
+		case rest[end] == 'W' && !inTime:
+			unit = 7 * 24 * time.Hour
+		case rest[end] == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case rest[end] == 'H' && inTime:
+			unit = time.Hour
+		case rest[end] == 'M' && inTime:
+			unit = time.Minute
+		case rest[end] == 'S' && inTime:
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("unsupported duration: %v", s)
+		}
+
+		total += time.Duration(n * float64(unit))
+		rest = rest[end+1:]
+	}
+
+	return total, nil
+}
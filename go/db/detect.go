@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaDetector is implemented by the databases which can read the schema of
+// their existing table, so the commands can check that the schema they were
+// given matches the table instead of misreading it.
+type SchemaDetector interface {
+	// DetectSchema reads the schema of the existing data_objects table or view.
+	DetectSchema() (Schema, error)
+}
+
+// schemaOfColumns returns the schema of a data_objects table with the given
+// columns and their types. valueTypes maps the types of the value columns as
+// the database reports them (lower case) to the value types.
+func schemaOfColumns(columns map[string]string, valueTypes map[string]ValueType, normalized bool) (Schema, error) {
+	if len(columns) == 0 {
+		return Schema{}, fmt.Errorf("%v does not exist", DB_TABLE_NAME)
+	}
+
+	valueType, ok := valueTypes[strings.ToLower(columns["value"])]
+	if !ok {
+		return Schema{}, fmt.Errorf("unknown type of the value column: %q", columns["value"])
+	}
+
+	schema := Schema{ValueType: valueType, Fields: 1, Normalized: normalized}
+	for {
+		if _, ok := columns[fmt.Sprintf("value_%d", schema.Fields)]; !ok {
+			break
+		}
+		schema.Fields++
+	}
+	_, schema.Labels = columns["labels"]
+
+	return schema, nil
+}
+
+// sqlColumns returns the types of the columns of the query, which selects the
+// column_name and the data_type of information_schema.columns.
+func sqlColumns(conn *sql.DB, query string, args ...any) (map[string]string, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = dataType
+	}
+
+	return columns, rows.Err()
+}
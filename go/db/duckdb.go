@@ -2,14 +2,19 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
+	"time"
 
 	_ "github.com/marcboeker/go-duckdb"
 )
 
 type DuckDB struct {
-	db   *sql.DB
-	name string
+	db     *sql.DB
+	name   string
+	schema Schema
+	series seriesCache
 }
 
 func NewDuckDB(name, filepath string) (*DuckDB, error) {
@@ -19,8 +24,9 @@ func NewDuckDB(name, filepath string) (*DuckDB, error) {
 	}
 
 	return &DuckDB{
-		db:   db,
-		name: name,
+		db:     db,
+		name:   name,
+		schema: DefaultSchema,
 	}, nil
 }
 
@@ -28,12 +34,70 @@ func (d *DuckDB) GetName() string {
 	return d.name
 }
 
-func (d *DuckDB) Setup() error {
-	_, err := d.db.Exec(`DROP TABLE IF EXISTS ` + DB_TABLE_NAME)
+func (d *DuckDB) SetSchema(schema Schema) error {
+	schema, err := schema.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	d.schema = schema
+	d.series = seriesCache{}
+	return nil
+}
+
+var duckdbValueTypes = map[ValueType]string{
+	VALUE_TYPE_FLOAT64: "DOUBLE",
+	VALUE_TYPE_INT64:   "BIGINT",
+	VALUE_TYPE_BOOL:    "BOOLEAN",
+	VALUE_TYPE_STRING:  "TEXT",
+}
+
+// TEXT is reported as VARCHAR by information_schema.columns.
+var duckdbColumnValueTypes = map[string]ValueType{
+	"double":  VALUE_TYPE_FLOAT64,
+	"bigint":  VALUE_TYPE_INT64,
+	"boolean": VALUE_TYPE_BOOL,
+	"varchar": VALUE_TYPE_STRING,
+}
+
+func (d *DuckDB) DetectSchema() (Schema, error) {
+	columns, err := sqlColumns(d.db, `SELECT column_name, data_type FROM information_schema.columns WHERE table_name = ?`, DB_TABLE_NAME)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	var normalized bool
+	err = d.db.QueryRow(`SELECT count(*) > 0 FROM information_schema.tables WHERE table_name = ?`, DATA_POINTS_TABLE_NAME).Scan(&normalized)
 	if err != nil {
+		return Schema{}, err
+	}
+
+	return schemaOfColumns(columns, duckdbColumnValueTypes, normalized)
+}
+
+func (d *DuckDB) Setup() error {
+	var tableType string
+	err := d.db.QueryRow(`SELECT table_type FROM information_schema.tables WHERE table_name = ?`, DB_TABLE_NAME).Scan(&tableType)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return err
 	}
 
+	for _, query := range []string{
+		dropDataObjects(tableType),
+		`DROP TABLE IF EXISTS ` + DATA_POINTS_TABLE_NAME,
+		`DROP TABLE IF EXISTS ` + SERIES_TABLE_NAME,
+		`DROP SEQUENCE IF EXISTS series_id`,
+	} {
+		if _, err := d.db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	d.series = seriesCache{}
+	if d.schema.Normalized {
+		return d.setupNormalized()
+	}
+
 	_, err = d.db.Exec(fmt.Sprintf(`
 		CREATE TABLE %v (
 			created_at  TIMESTAMP NOT NULL,
@@ -42,30 +106,84 @@ func (d *DuckDB) Setup() error {
 			interval    BIGINT    NOT NULL,
 			area        TEXT      NOT NULL,
 			source      TEXT      NOT NULL,
-			value       DOUBLE    NOT NULL,
+			%v,
 			UNIQUE(start_time, interval, area)
 		);
-	`, DB_TABLE_NAME))
+	`, DB_TABLE_NAME, d.schema.columnDefinitions(duckdbValueTypes[d.schema.ValueType], "JSON")))
 	return err
 }
 
+func (d *DuckDB) setupNormalized() error {
+	for _, query := range []string{
+		`CREATE SEQUENCE series_id`,
+		fmt.Sprintf(`
+			CREATE TABLE %v (
+				id        BIGINT PRIMARY KEY DEFAULT nextval('series_id'),
+				area      TEXT   NOT NULL,
+				source    TEXT   NOT NULL,
+				interval  BIGINT NOT NULL,
+				UNIQUE(area, interval)
+			);
+		`, SERIES_TABLE_NAME),
+		fmt.Sprintf(`
+			CREATE TABLE %v (
+				series_id   BIGINT    NOT NULL REFERENCES %v (id),
+				created_at  TIMESTAMP NOT NULL,
+				updated_at  TIMESTAMP NOT NULL,
+				start_time  TIMESTAMP NOT NULL,
+				%v,
+				PRIMARY KEY(series_id, start_time)
+			);
+		`, DATA_POINTS_TABLE_NAME, SERIES_TABLE_NAME, d.schema.columnDefinitions(duckdbValueTypes[d.schema.ValueType], "JSON")),
+		`CREATE VIEW ` + DB_TABLE_NAME + ` AS ` + d.schema.normalizedView("interval"),
+	} {
+		if _, err := d.db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upsertSeries writes the series of the doc and returns its id. duckdb returns
+// the id of a new row with RETURNING even if the row already existed, so the
+// id is selected after the upsert.
+func (d *DuckDB) upsertSeries(doc DataObject) (int64, error) {
+	_, err := d.db.Exec(fmt.Sprintf(`
+		INSERT INTO %v (area, source, interval) VALUES (?, ?, ?)
+		ON CONFLICT(area, interval) DO UPDATE SET source = EXCLUDED.source;
+	`, SERIES_TABLE_NAME), doc.Area, doc.Source, doc.Interval)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = d.db.QueryRow(fmt.Sprintf(`SELECT id FROM %v WHERE area = ? AND interval = ?`, SERIES_TABLE_NAME), doc.Area, doc.Interval).Scan(&id)
+	return id, err
+}
+
+func (d *DuckDB) upsertQuery() string {
+	return fmt.Sprintf(`
+		INSERT INTO %v (%v)
+		VALUES (%v)
+		ON CONFLICT(%v) DO UPDATE SET %v;
+	`, d.schema.dataTable(), d.schema.writeColumns("interval"), d.schema.placeholders(false), d.schema.upsertConflictTarget(), d.schema.upsertSetClause("%[1]v = EXCLUDED.%[1]v"))
+}
+
 func (d *DuckDB) Close() error {
 	return d.db.Close()
 }
 
 func (d *DuckDB) UpsertSingle(docs []DataObject) error {
-	query := fmt.Sprintf(`
-		INSERT INTO %v (created_at, updated_at, start_time, interval, area, source, value)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(start_time, interval, area) DO UPDATE SET
-			updated_at = EXCLUDED.updated_at,
-			source = EXCLUDED.source,
-			value = EXCLUDED.value;
-	`, DB_TABLE_NAME)
+	query := d.upsertQuery()
 
 	for _, doc := range docs {
-		_, err := d.db.Exec(query,
-			doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source, doc.Value)
+		values, err := d.schema.writeValues([]DataObject{doc}, d.series, d.upsertSeries)
+		if err != nil {
+			return fmt.Errorf("UpsertSingle: %w", err)
+		}
+
+		_, err = d.db.Exec(query, values[0]...)
 		if err != nil {
 			return fmt.Errorf("UpsertSingle: %w", err)
 		}
@@ -75,30 +193,25 @@ func (d *DuckDB) UpsertSingle(docs []DataObject) error {
 }
 
 func (d *DuckDB) UpsertBulk(docs []DataObject) error {
+	rows, err := d.schema.writeValues(docs, d.series, d.upsertSeries)
+	if err != nil {
+		return fmt.Errorf("UpsertBulk: %w", err)
+	}
+
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	query := fmt.Sprintf(`
-		INSERT INTO %v (created_at, updated_at, start_time, interval, area, source, value)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(start_time, interval, area) DO UPDATE SET
-			updated_at = EXCLUDED.updated_at,
-			source = EXCLUDED.source,
-			value = EXCLUDED.value;
-	`, DB_TABLE_NAME)
-
-	stmt, err := tx.Prepare(query)
+	stmt, err := tx.Prepare(d.upsertQuery())
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	for _, doc := range docs {
-		if _, err := stmt.Exec(
-			doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source, doc.Value); err != nil {
+	for _, values := range rows {
+		if _, err := stmt.Exec(values...); err != nil {
 			return fmt.Errorf("UpsertBulk: %w", err)
 		}
 	}
@@ -107,23 +220,162 @@ func (d *DuckDB) UpsertBulk(docs []DataObject) error {
 }
 
 func (d *DuckDB) GetOrderedWithLimit(limit int) ([]DataObject, error) {
-	query := fmt.Sprintf(`SELECT created_at, updated_at, start_time, interval, area, source, value FROM %v ORDER BY start_time DESC LIMIT %d`, DB_TABLE_NAME, limit)
+	query := fmt.Sprintf(`SELECT %v FROM %v ORDER BY start_time DESC LIMIT %d`, d.schema.columns("interval"), DB_TABLE_NAME, limit)
 	rows, err := d.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	results := make([]DataObject, 0, limit)
+	for rows.Next() {
+		obj, err := scanDataObject(rows, d.schema)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, obj)
+	}
+
+	return results, rows.Err()
+}
+
+func (d *DuckDB) StreamAll() iter.Seq2[DataObject, error] {
+	return func(yield func(DataObject, error) bool) {
+		query := fmt.Sprintf(`SELECT %v FROM %v`, d.schema.columns("interval"), DB_TABLE_NAME)
+
+		rows, err := d.db.Query(query)
+		if err != nil {
+			yield(DataObject{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			obj, err := scanDataObject(rows, d.schema)
+			if !yield(obj, err) || err != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(DataObject{}, err)
+		}
+	}
+}
+
+func (d *DuckDB) GetOne(startTime time.Time, interval int64, area string) (DataObject, error) {
+	query := fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE start_time = ? AND interval = ? AND area = ?`, d.schema.columns("interval"), DB_TABLE_NAME)
+
+	obj, err := scanDataObject(d.db.QueryRow(query, startTime, interval, area), d.schema)
+	if errors.Is(err, sql.ErrNoRows) {
+		return obj, ErrNotFound
+	}
+
+	return obj, err
+}
+
+func (d *DuckDB) GetLatestPerArea() ([]DataObject, error) {
+	query := fmt.Sprintf(`
+		SELECT %v FROM %v
+		QUALIFY row_number() OVER (PARTITION BY area ORDER BY start_time DESC, interval DESC) = 1
+		ORDER BY area`, d.schema.columns("interval"), DB_TABLE_NAME)
+
+	return d.queryDataObjects(query)
+}
+
+func (d *DuckDB) GetPageBefore(cursor *Cursor, limit int) ([]DataObject, error) {
+	if cursor == nil {
+		return d.queryDataObjects(fmt.Sprintf(`
+			SELECT %v FROM %v
+			ORDER BY start_time DESC, interval DESC, area DESC LIMIT ?`, d.schema.columns("interval"), DB_TABLE_NAME), limit)
+	}
+
+	// duckdb can't bind parameters inside of a row comparison, so it is expanded.
+	return d.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE start_time < ? OR (start_time = ? AND (interval < ? OR (interval = ? AND area < ?)))
+		ORDER BY start_time DESC, interval DESC, area DESC LIMIT ?`, d.schema.columns("interval"), DB_TABLE_NAME),
+		cursor.StartTime, cursor.StartTime, cursor.Interval, cursor.Interval, cursor.Area, limit)
+}
+
+func (d *DuckDB) GetPageOffset(offset, limit int) ([]DataObject, error) {
+	return d.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		ORDER BY start_time DESC, interval DESC, area DESC LIMIT ? OFFSET ?`, d.schema.columns("interval"), DB_TABLE_NAME), limit, offset)
+}
+
+func (d *DuckDB) queryDataObjects(query string, args ...any) ([]DataObject, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
 	var results []DataObject
 	for rows.Next() {
-		var obj DataObject
-		if err := rows.Scan(&obj.CreatedAt, &obj.UpdatedAt, &obj.StartTime, &obj.Interval, &obj.Area, &obj.Source, &obj.Value); err != nil {
+		obj, err := scanDataObject(rows, d.schema)
+		if err != nil {
 			return nil, err
 		}
 		results = append(results, obj)
 	}
 
-	return results, nil
+	return results, rows.Err()
+}
+
+// GetByLabels filters with json_contains. duckdb has no index for JSON, so
+// every row is scanned.
+func (d *DuckDB) GetByLabels(labels map[string]string, limit int) ([]DataObject, error) {
+	if !d.schema.Labels {
+		return nil, errNoLabels
+	}
+
+	return d.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE json_contains(labels, ?)
+		ORDER BY start_time DESC LIMIT ?`, d.schema.columns("interval"), DB_TABLE_NAME), encodeLabels(labels), limit)
+}
+
+func (d *DuckDB) GetAggregated(bucket Bucket, from, to time.Time) ([]AggregateObject, error) {
+	if err := bucket.validate(); err != nil {
+		return nil, err
+	}
+
+	value, err := d.schema.ValueType.aggregateExpr("value", "%v::DOUBLE")
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT date_trunc('%v', start_time) AS bucket, area, count(*), min(%[3]v), max(%[3]v), avg(%[3]v)
+		FROM %[2]v WHERE start_time >= ? AND start_time < ?
+		GROUP BY bucket, area ORDER BY bucket, area`, bucket, DB_TABLE_NAME, value)
+
+	rows, err := d.db.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []AggregateObject
+	for rows.Next() {
+		var obj AggregateObject
+		if err := rows.Scan(&obj.Bucket, &obj.Area, &obj.Count, &obj.Min, &obj.Max, &obj.Avg); err != nil {
+			return nil, err
+		}
+		results = append(results, obj)
+	}
+
+	return results, rows.Err()
+}
+
+func (d *DuckDB) Count() (int64, error) {
+	var count int64
+	err := d.db.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %v`, DB_TABLE_NAME)).Scan(&count)
+	return count, err
 }
 
 func (d *DuckDB) TableSizeInKB() (int, error) {
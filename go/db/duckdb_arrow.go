@@ -0,0 +1,74 @@
+//go:build !no_duckdb_arrow
+
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// The arrow read path returns the columnar record batches of duckdb as they
+// are, without scanning every row into a DataObject. The caller has to release
+// the returned reader.
+
+// GetOrderedWithLimitArrow is the arrow version of GetOrderedWithLimit.
+func (d *DuckDB) GetOrderedWithLimitArrow(limit int) (array.RecordReader, error) {
+	return d.queryArrow(fmt.Sprintf(`
+		SELECT %v FROM %v
+		ORDER BY start_time DESC LIMIT ?`, d.schema.columns("interval"), DB_TABLE_NAME), limit)
+}
+
+// GetRangeArrow returns every row with a start_time in [from, to).
+func (d *DuckDB) GetRangeArrow(from, to time.Time) (array.RecordReader, error) {
+	return d.queryArrow(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE start_time >= ? AND start_time < ?
+		ORDER BY start_time`, d.schema.columns("interval"), DB_TABLE_NAME), from, to)
+}
+
+// GetAggregatedArrow is GetAggregated with the columns bucket, area, count,
+// min, max and avg.
+func (d *DuckDB) GetAggregatedArrow(bucket Bucket, from, to time.Time) (array.RecordReader, error) {
+	if err := bucket.validate(); err != nil {
+		return nil, err
+	}
+
+	value, err := d.schema.ValueType.aggregateExpr("value", "%v::DOUBLE")
+	if err != nil {
+		return nil, err
+	}
+
+	return d.queryArrow(fmt.Sprintf(`
+		SELECT date_trunc('%v', start_time) AS bucket, area, count(*) AS count,
+			min(%[3]v) AS min, max(%[3]v) AS max, avg(%[3]v) AS avg
+		FROM %[2]v WHERE start_time >= ? AND start_time < ?
+		GROUP BY bucket, area ORDER BY bucket, area`, bucket, DB_TABLE_NAME, value), from, to)
+}
+
+func (d *DuckDB) queryArrow(query string, args ...any) (array.RecordReader, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("queryArrow: %v", err)
+	}
+	defer conn.Close()
+
+	var reader array.RecordReader
+	err = conn.Raw(func(driverConn any) error {
+		arrow, err := duckdb.NewArrowFromConn(driverConn.(driver.Conn))
+		if err != nil {
+			return err
+		}
+
+		reader, err = arrow.QueryContext(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("queryArrow: %v", err)
+	}
+
+	return reader, nil
+}
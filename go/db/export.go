@@ -0,0 +1,109 @@
+package db
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	EXPORT_FORMAT_CSV   ExportFormat = "csv"
+	EXPORT_FORMAT_JSONL ExportFormat = "jsonl"
+)
+
+// The columns of an export, in the order of the tables. A CSV export can be
+// read back with DefaultCSVMapping and the ValueType of the table.
+var exportColumns = []string{"created_at", "updated_at", "start_time", "interval", "area", "source", "value"}
+
+// Export streams every row of the database to w and returns the number of
+// written rows. The timestamps are written as RFC3339 in UTC.
+func Export(w io.Writer, dbInstance Database, format ExportFormat) (int, error) {
+	var (
+		count int
+		err   error
+	)
+
+	switch format {
+	case EXPORT_FORMAT_CSV:
+		count, err = exportCSV(w, dbInstance)
+	case EXPORT_FORMAT_JSONL:
+		count, err = exportJSONL(w, dbInstance)
+	default:
+		return 0, fmt.Errorf("unknown export format: %v", format)
+	}
+
+	if err != nil {
+		return count, fmt.Errorf("Export: %v", err)
+	}
+
+	return count, nil
+}
+
+func exportCSV(w io.Writer, dbInstance Database) (int, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	record := make([]string, len(exportColumns))
+	for obj, err := range dbInstance.StreamAll() {
+		if err != nil {
+			return count, err
+		}
+
+		for i, column := range exportColumns {
+			record[i] = formatCSVValue(obj.fieldValue(column))
+		}
+
+		if err := writer.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	writer.Flush()
+	return count, writer.Error()
+}
+
+func formatCSVValue(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func exportJSONL(w io.Writer, dbInstance Database) (int, error) {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+
+	count := 0
+	for obj, err := range dbInstance.StreamAll() {
+		if err != nil {
+			return count, err
+		}
+
+		obj.CreatedAt = obj.CreatedAt.UTC()
+		obj.UpdatedAt = obj.UpdatedAt.UTC()
+		obj.StartTime = obj.StartTime.UTC()
+
+		if err := encoder.Encode(obj); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, buf.Flush()
+}
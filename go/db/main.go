@@ -2,18 +2,45 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"iter"
 	"math/rand"
 	"time"
 )
 
 type Database interface {
 	GetName() string
+	// SetSchema changes the schema of the table used by the next call of Setup.
+	SetSchema(schema Schema) error
 	Setup() error
 	Close() error
 	TableSizeInKB() (int, error)
+	// Count returns the number of rows in the table.
+	Count() (int64, error)
 	UpsertSingle(docs []DataObject) error
 	UpsertBulk(docs []DataObject) error
 	GetOrderedWithLimit(limit int) ([]DataObject, error)
+	// StreamAll reads every row of the table, in no particular order, without
+	// keeping them in memory. The iteration stops at the first error.
+	StreamAll() iter.Seq2[DataObject, error]
+	// GetOne returns ErrNotFound if there is no row with the given key.
+	GetOne(startTime time.Time, interval int64, area string) (DataObject, error)
+	// GetPageBefore returns up to limit rows which come after the cursor in the
+	// order of start_time, interval and area descending. A nil cursor starts at
+	// the latest row. The next page starts at the cursor of the last row.
+	GetPageBefore(cursor *Cursor, limit int) ([]DataObject, error)
+	// GetPageOffset returns the rows of a page in the same order as
+	// GetPageBefore, after skipping offset rows.
+	GetPageOffset(offset, limit int) ([]DataObject, error)
+	// GetLatestPerArea returns the row with the latest start_time of every
+	// area, whole rows only. If an area has several intervals at its latest
+	// start_time, the row with the largest interval is returned.
+	GetLatestPerArea() ([]DataObject, error)
+	GetAggregated(bucket Bucket, from, to time.Time) ([]AggregateObject, error)
+	// GetByLabels returns up to limit rows, newest first, which have all of the
+	// given labels. Only supported with Schema.Labels.
+	GetByLabels(labels map[string]string, limit int) ([]DataObject, error)
 }
 
 const (
@@ -29,13 +56,57 @@ const (
 )
 
 type DataObject struct {
-	CreatedAt time.Time `bson:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at"`
-	StartTime time.Time `bson:"start_time"`
-	Interval  int64     `bson:"interval"`
-	Area      string    `bson:"area"`
-	Source    string    `bson:"source"`
-	Value     float64   `bson:"value"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	StartTime time.Time `bson:"start_time" json:"start_time"`
+	Interval  int64     `bson:"interval" json:"interval"`
+	Area      string    `bson:"area" json:"area"`
+	Source    string    `bson:"source" json:"source"`
+	// The value of the go type of Schema.ValueType, float64 by default.
+	Value any `bson:"value" json:"value"`
+	// The values of the additional value columns of a wide schema, see
+	// Schema.Fields.
+	Values []any `bson:"values,omitempty" json:"values,omitempty"`
+	// The labels of the series, stored with Schema.Labels.
+	Labels map[string]string `bson:"labels,omitempty" json:"labels,omitempty"`
+}
+
+var ErrNotFound = errors.New("not found")
+
+// Cursor is the key of the last row of a page, used for keyset pagination.
+type Cursor struct {
+	StartTime time.Time `json:"start_time"`
+	Interval  int64     `json:"interval"`
+	Area      string    `json:"area"`
+}
+
+// Bucket is the width of the time buckets of an aggregation. The values match
+// the units of date_trunc, so they can be used in the queries directly.
+type Bucket string
+
+const (
+	BUCKET_HOUR Bucket = "hour"
+	BUCKET_DAY  Bucket = "day"
+)
+
+func (b Bucket) validate() error {
+	switch b {
+	case BUCKET_HOUR, BUCKET_DAY:
+		return nil
+	default:
+		return fmt.Errorf("unknown bucket: %q", b)
+	}
+}
+
+// AggregateObject holds the aggregated values of a single area in a single
+// time bucket.
+type AggregateObject struct {
+	Bucket time.Time `bson:"bucket"`
+	Area   string    `bson:"area"`
+	Count  int64     `bson:"count"`
+	Min    float64   `bson:"min"`
+	Max    float64   `bson:"max"`
+	Avg    float64   `bson:"avg"`
 }
 
 var (
@@ -43,25 +114,162 @@ var (
 	ctx      = context.Background()
 )
 
+// Cursor returns the cursor which continues after this row.
+func (o DataObject) Cursor() *Cursor {
+	return &Cursor{StartTime: o.StartTime, Interval: o.Interval, Area: o.Area}
+}
+
+// rowScanner is implemented by the rows of pgx and database/sql.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanDataObject scans a row of the SQL databases. The columns have to be
+// selected in the order of the DataObject fields.
+func scanDataObject(row rowScanner, schema Schema) (DataObject, error) {
+	var obj DataObject
+	values := schema.scanDests()
+	dest := append([]any{&obj.CreatedAt, &obj.UpdatedAt, &obj.StartTime, &obj.Interval, &obj.Area, &obj.Source}, values...)
+	if schema.Labels {
+		dest = append(dest, (*labelsScanner)(&obj.Labels))
+	}
+	if err := row.Scan(dest...); err != nil {
+		return obj, err
+	}
+
+	schema.scanned(&obj, values)
+	return obj, nil
+}
+
 func GenerateFakeData(numObjects int) []DataObject {
 	rows := make([]DataObject, numObjects)
 
 	for i := range numObjects {
-		now := time.Now().UTC()
 		startTime := BaseTime.Add(time.Duration(i) * time.Hour)
+		rows[i] = newFakeObject(startTime, rand.Float64())
+	}
+
+	return rows
+}
+
+// The statuses of the fake string values.
+var FAKE_STATUSES = []string{"ok", "warning", "alarm", "maintenance", "offline"}
+
+// GenerateFakeDataOfType generates numObjects hourly rows with values of the
+// go type of the value type which look like the type: random floats, a
+// counter which only increases and flags / statuses which rarely change.
+func GenerateFakeDataOfType(numObjects int, valueType ValueType) []DataObject {
+	rows := make([]DataObject, numObjects)
+
+	var (
+		counter int64
+		flag    bool
+		status  = FAKE_STATUSES[0]
+	)
+	for i := range numObjects {
+		var value any
+		switch valueType {
+		case VALUE_TYPE_INT64:
+			counter += rand.Int63n(100)
+			value = counter
+		case VALUE_TYPE_BOOL:
+			if rand.Float64() < 0.01 {
+				flag = !flag
+			}
+			value = flag
+		case VALUE_TYPE_STRING:
+			if rand.Float64() < 0.01 {
+				status = FAKE_STATUSES[rand.Intn(len(FAKE_STATUSES))]
+			}
+			value = status
+		default:
+			value = rand.Float64()
+		}
+
+		rows[i] = newFakeObject(BaseTime.Add(time.Duration(i)*time.Hour), value)
+	}
+
+	return rows
+}
+
+// GenerateFakeDataForSchema generates numObjects hourly rows with values of
+// the value type of the schema, and a separate series for every additional
+// value column of a wide schema.
+func GenerateFakeDataForSchema(numObjects int, schema Schema) []DataObject {
+	rows := GenerateFakeDataOfType(numObjects, schema.ValueType)
+
+	for i := range rows {
+		rows[i].Values = make([]any, 0, schema.Fields-1)
+	}
+
+	for field := 1; field < schema.Fields; field++ {
+		for i, row := range GenerateFakeDataOfType(numObjects, schema.ValueType) {
+			rows[i].Values = append(rows[i].Values, row.Value)
+		}
+	}
 
-		obj := DataObject{
-			CreatedAt: now,
-			UpdatedAt: now,
-			StartTime: startTime,
-			Interval:  3600000, // 1 hour in milliseconds
-			Area:      "lv",
-			Source:    "source-of-data",
-			Value:     rand.Float64(),
+	if schema.Labels {
+		for i := range rows {
+			rows[i].Labels = FakeLabels(0)
 		}
+	}
+
+	return rows
+}
+
+var (
+	FAKE_LABEL_REGIONS = []string{"eu-north", "eu-west", "us-east", "ap-south"}
+	FAKE_LABEL_KINDS   = []string{"meter", "sensor", "inverter"}
+)
+
+// FakeLabels returns the labels of the series of the area with the given
+// index: a unique device, one of 4 regions and one of 3 kinds.
+func FakeLabels(area int) map[string]string {
+	return map[string]string{
+		"device": fmt.Sprintf("device-%04d", area),
+		"region": FAKE_LABEL_REGIONS[area%len(FAKE_LABEL_REGIONS)],
+		"kind":   FAKE_LABEL_KINDS[area%len(FAKE_LABEL_KINDS)],
+	}
+}
+
+// GenerateFakeLabeledSeries is GenerateFakeSeries with the FakeLabels of the
+// areas.
+func GenerateFakeLabeledSeries(numAreas, hoursPerArea int) []DataObject {
+	rows := GenerateFakeSeries(numAreas, hoursPerArea)
+	for i := range rows {
+		rows[i].Labels = FakeLabels(i % numAreas)
+	}
+
+	return rows
+}
+
+// GenerateFakeSeries generates hoursPerArea hourly rows for each of numAreas
+// areas, ordered by start_time and area.
+func GenerateFakeSeries(numAreas, hoursPerArea int) []DataObject {
+	rows := make([]DataObject, 0, numAreas*hoursPerArea)
 
-		rows[i] = obj
+	for i := range hoursPerArea {
+		startTime := BaseTime.Add(time.Duration(i) * time.Hour)
+		for a := range numAreas {
+			obj := newFakeObject(startTime, rand.Float64())
+			obj.Area = fmt.Sprintf("area-%04d", a)
+			rows = append(rows, obj)
+		}
 	}
 
 	return rows
 }
+
+func newFakeObject(startTime time.Time, value any) DataObject {
+	now := time.Now().UTC()
+
+	return DataObject{
+		CreatedAt: now,
+		UpdatedAt: now,
+		StartTime: startTime,
+		Interval:  3600000, // 1 hour in milliseconds
+		Area:      "lv",
+		Source:    "source-of-data",
+		Value:     value,
+	}
+}
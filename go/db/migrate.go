@@ -0,0 +1,46 @@
+package db
+
+import "fmt"
+
+// MigrationState is the progress of a migration. Cursor is the key of the last
+// row which was written to the destination, a nil cursor starts at the latest
+// row of the source.
+type MigrationState struct {
+	Cursor *Cursor `json:"cursor"`
+	Copied int64   `json:"copied"`
+	Done   bool    `json:"done"`
+}
+
+// Migrate copies the rows of from into to, batchSize rows at a time in the
+// order of GetPageBefore, starting after the cursor of the state. save is
+// called with the new state after every written batch, so an interrupted
+// migration can continue from the last saved state. The rows are written with
+// UpsertBulk, so writing a batch a second time is safe.
+func Migrate(from, to Database, batchSize int, state MigrationState, save func(MigrationState) error) (MigrationState, error) {
+	if batchSize <= 0 {
+		return state, fmt.Errorf("Migrate: invalid batch size: %v", batchSize)
+	}
+
+	for !state.Done {
+		docs, err := from.GetPageBefore(state.Cursor, batchSize)
+		if err != nil {
+			return state, fmt.Errorf("Migrate: read from %v: %v", from.GetName(), err)
+		}
+
+		if len(docs) > 0 {
+			if err := to.UpsertBulk(docs); err != nil {
+				return state, fmt.Errorf("Migrate: write to %v: %v", to.GetName(), err)
+			}
+
+			state.Cursor = docs[len(docs)-1].Cursor()
+			state.Copied += int64(len(docs))
+		}
+		state.Done = len(docs) < batchSize
+
+		if err := save(state); err != nil {
+			return state, fmt.Errorf("Migrate: save state: %v", err)
+		}
+	}
+
+	return state, nil
+}
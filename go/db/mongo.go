@@ -1,18 +1,211 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"iter"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MongoDB struct {
-	conn *mongo.Client
-	coll *mongo.Collection
-	name string
+	conn   *mongo.Client
+	coll   *mongo.Collection
+	name   string
+	opts   MongoOptions
+	schema Schema
+	// Set in Setup if the server rejects upserts into the time-series
+	// collection, the rows are then replaced by a delete + insert.
+	timeSeriesUpsertFallback bool
+}
+
+type MongoOptions struct {
+	// Creates a time-series collection with start_time as the timeField and
+	// area, source and interval in the metaField, instead of a plain collection.
+	TimeSeries bool
+	// Granularity of the time-series collection: "seconds", "minutes" or "hours".
+	Granularity string
+	// How the upserts are written, MONGO_WRITE_SET_ON_INSERT by default.
+	WriteStrategy MongoWriteStrategy
+	// Lets the server apply the writes of UpsertBulk and InsertMany in any
+	// order, without stopping at the first error.
+	UnorderedBulk bool
+	// Always uses the delete + insert path of the servers which can't upsert
+	// into time-series collections, to test it on newer servers.
+	ForceUpsertFallback bool
+}
+
+type MongoWriteStrategy string
+
+const (
+	// UpdateOne upserts which follow the upsert semantics of all of the
+	// backends: $setOnInsert for the insert-only fields and $set for the
+	// updatable fields.
+	MONGO_WRITE_SET_ON_INSERT MongoWriteStrategy = ""
+	// UpdateOne upserts with $set of the whole document. Overwrites created_at,
+	// so it is only used to compare the cost.
+	MONGO_WRITE_SET_ALL MongoWriteStrategy = "set-all"
+	// ReplaceOne upserts of the whole document. Overwrites created_at, so it
+	// is only used to compare the cost.
+	MONGO_WRITE_REPLACE MongoWriteStrategy = "replace"
+)
+
+// The time-series collections store the fields which identify a series in
+// a single metaField.
+const MONGO_META_FIELD = "meta"
+
+// The area of the row with which Setup checks if the server supports upserts
+// into time-series collections.
+const MONGO_UPSERT_PROBE_AREA = "upsert-probe"
+
+// The code with which the servers which can't upsert into time-series
+// collections reject the upserts: InvalidOptions. Some servers use the generic
+// IllegalOperation instead, which only counts with a message about time-series
+// collections.
+const (
+	MONGO_TIME_SERIES_UPSERT_ERROR_CODE = 72
+	MONGO_ILLEGAL_OPERATION_ERROR_CODE  = 20
+)
+
+type mongoMeta struct {
+	Area     string            `bson:"area"`
+	Source   string            `bson:"source"`
+	Interval int64             `bson:"interval"`
+	Labels   map[string]string `bson:"labels,omitempty"`
+}
+
+// mongoObject is the shape of a DataObject in a plain collection, with the
+// values in the type of the schema. The additional value fields of a wide
+// schema (value_1, ...) are inlined into the document.
+type mongoObject struct {
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	StartTime time.Time `bson:"start_time"`
+	Interval  int64     `bson:"interval"`
+	Area      string    `bson:"area"`
+	Source    string    `bson:"source"`
+	Value     any       `bson:"value"`
+	Fields    bson.M    `bson:",inline"`
+	// The labels subdocument of Schema.Labels.
+	Labels map[string]string `bson:"labels,omitempty"`
+}
+
+func newMongoObject(doc DataObject, schema Schema) mongoObject {
+	return mongoObject{
+		CreatedAt: doc.CreatedAt,
+		UpdatedAt: doc.UpdatedAt,
+		StartTime: doc.StartTime,
+		Interval:  doc.Interval,
+		Area:      doc.Area,
+		Source:    doc.Source,
+		Value:     doc.Value,
+		Fields:    mongoFields(doc, schema),
+		Labels:    mongoLabels(doc, schema),
+	}
+}
+
+func (obj mongoObject) dataObject(schema Schema) (DataObject, error) {
+	doc := DataObject{
+		CreatedAt: obj.CreatedAt,
+		UpdatedAt: obj.UpdatedAt,
+		StartTime: obj.StartTime,
+		Interval:  obj.Interval,
+		Area:      obj.Area,
+		Source:    obj.Source,
+		Labels:    obj.Labels,
+	}
+
+	err := decodeMongoValues(&doc, obj.Value, obj.Fields, schema)
+	return doc, err
+}
+
+// mongoTimeSeriesObject is the shape of a DataObject in a time-series collection.
+type mongoTimeSeriesObject struct {
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	StartTime time.Time `bson:"start_time"`
+	Meta      mongoMeta `bson:"meta"`
+	Value     any       `bson:"value"`
+	Fields    bson.M    `bson:",inline"`
+}
+
+func newMongoTimeSeriesObject(doc DataObject, schema Schema) mongoTimeSeriesObject {
+	return mongoTimeSeriesObject{
+		CreatedAt: doc.CreatedAt,
+		UpdatedAt: doc.UpdatedAt,
+		StartTime: doc.StartTime,
+		Meta:      mongoMeta{Area: doc.Area, Source: doc.Source, Interval: doc.Interval, Labels: mongoLabels(doc, schema)},
+		Value:     doc.Value,
+		Fields:    mongoFields(doc, schema),
+	}
+}
+
+func (obj mongoTimeSeriesObject) dataObject(schema Schema) (DataObject, error) {
+	doc := DataObject{
+		CreatedAt: obj.CreatedAt,
+		UpdatedAt: obj.UpdatedAt,
+		StartTime: obj.StartTime,
+		Interval:  obj.Meta.Interval,
+		Area:      obj.Meta.Area,
+		Source:    obj.Meta.Source,
+		Labels:    obj.Meta.Labels,
+	}
+
+	err := decodeMongoValues(&doc, obj.Value, obj.Fields, schema)
+	return doc, err
+}
+
+// mongoFields returns the additional value fields of a wide schema, nil for
+// the narrow schema.
+func mongoFields(doc DataObject, schema Schema) bson.M {
+	if schema.Fields <= 1 {
+		return nil
+	}
+
+	fields := bson.M{}
+	for i, field := range schema.valueColumns()[1:] {
+		fields[field] = schema.value(doc, i+1)
+	}
+
+	return fields
+}
+
+// mongoLabels returns the labels of the doc, nil if the schema has no labels.
+func mongoLabels(doc DataObject, schema Schema) map[string]string {
+	if !schema.Labels {
+		return nil
+	}
+
+	return doc.Labels
+}
+
+// decodeMongoValues decodes the value and the additional value fields into
+// the doc. The inlined fields also contain the _id of the document.
+func decodeMongoValues(doc *DataObject, value any, fields bson.M, schema Schema) error {
+	var err error
+	if doc.Value, err = schema.ValueType.decode(value); err != nil {
+		return err
+	}
+
+	if schema.Fields <= 1 {
+		return nil
+	}
+
+	doc.Values = make([]any, schema.Fields-1)
+	for i, field := range schema.valueColumns()[1:] {
+		if doc.Values[i], err = schema.ValueType.decode(fields[field]); err != nil {
+			return fmt.Errorf("%v: %v", field, err)
+		}
+	}
+
+	return nil
 }
 
 func NewMongoDB(name, host string, port int, username, password string) (*MongoDB, error) {
@@ -41,21 +234,109 @@ func NewMongoDB(name, host string, port int, username, password string) (*MongoD
 	}
 
 	return &MongoDB{
-		name: name,
-		conn: conn,
-		coll: conn.Database(DB_NAME).Collection(DB_TABLE_NAME),
+		name:   name,
+		conn:   conn,
+		coll:   conn.Database(DB_NAME).Collection(DB_TABLE_NAME),
+		schema: DefaultSchema,
 	}, nil
 }
 
+// SetOptions changes the options used by the next call of Setup.
+func (db *MongoDB) SetOptions(opts MongoOptions) {
+	if opts.TimeSeries && opts.Granularity == "" {
+		opts.Granularity = "hours"
+	}
+
+	db.opts = opts
+}
+
+func (db *MongoDB) Options() MongoOptions { return db.opts }
+
+func (db *MongoDB) SetSchema(schema Schema) error {
+	schema, err := schema.withDefaults()
+	if err != nil {
+		return err
+	}
+	if schema.Normalized {
+		return errNormalizedUnsupported
+	}
+
+	db.schema = schema
+	return nil
+}
+
 func (db *MongoDB) GetName() string { return db.name }
 
+// DetectSchema reads the schema of the collection from one of its documents
+// and fails if the type of the collection doesn't match MongoOptions.TimeSeries.
+// An empty collection matches the current schema, and as the labels of a
+// document can be empty, a document without them doesn't rule them out.
+func (db *MongoDB) DetectSchema() (Schema, error) {
+	specs, err := db.conn.Database(DB_NAME).ListCollectionSpecifications(ctx, bson.M{"name": DB_TABLE_NAME})
+	if err != nil {
+		return Schema{}, err
+	}
+	if len(specs) == 0 {
+		return Schema{}, fmt.Errorf("%v does not exist", DB_TABLE_NAME)
+	}
+	if timeSeries := specs[0].Type == "timeseries"; timeSeries != db.opts.TimeSeries {
+		return Schema{}, fmt.Errorf("%v is a time-series collection: %v, expected %v", DB_TABLE_NAME, timeSeries, db.opts.TimeSeries)
+	}
+
+	var doc bson.M
+	err = db.coll.FindOne(ctx, bson.M{}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return db.schema, nil
+	}
+	if err != nil {
+		return Schema{}, err
+	}
+
+	schema := Schema{Fields: 1}
+	switch value := doc["value"].(type) {
+	case float64:
+		schema.ValueType = VALUE_TYPE_FLOAT64
+	case int64, int32:
+		schema.ValueType = VALUE_TYPE_INT64
+	case bool:
+		schema.ValueType = VALUE_TYPE_BOOL
+	case string:
+		schema.ValueType = VALUE_TYPE_STRING
+	default:
+		return Schema{}, fmt.Errorf("unsupported value: %v (%T)", value, value)
+	}
+
+	for {
+		if _, ok := doc[fmt.Sprintf("value_%d", schema.Fields)]; !ok {
+			break
+		}
+		schema.Fields++
+	}
+
+	labels := doc["labels"]
+	if meta, ok := doc[MONGO_META_FIELD].(bson.M); ok && db.opts.TimeSeries {
+		labels = meta["labels"]
+	}
+	schema.Labels = labels != nil || db.schema.Labels
+
+	return schema, nil
+}
+
 func (db *MongoDB) Close() error { return db.conn.Disconnect(ctx) }
 
 func (db *MongoDB) Setup() error {
-	if _, err := db.coll.DeleteMany(ctx, bson.M{}); err != nil {
+	// The collection is dropped instead of emptied, because the type of the
+	// collection can not be changed afterwards.
+	if err := db.coll.Drop(ctx); err != nil {
 		return err
 	}
 
+	db.timeSeriesUpsertFallback = false
+
+	if db.opts.TimeSeries {
+		return db.setupTimeSeries()
+	}
+
 	// Create compound index on the "start_time", "interval" and "area" fields
 	if _, err := db.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.D{
@@ -72,16 +353,169 @@ func (db *MongoDB) Setup() error {
 		return err
 	}
 
+	// The keys of the labels are not known up front, so a wildcard index
+	// covers every field of the subdocument.
+	if db.schema.Labels {
+		if _, err := db.coll.Indexes().CreateOne(ctx,
+			mongo.IndexModel{Keys: bson.D{{Key: "labels.$**", Value: 1}}}); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (db *MongoDB) setupTimeSeries() error {
+	tsOpts := options.TimeSeries().
+		SetTimeField("start_time").
+		SetMetaField(MONGO_META_FIELD).
+		SetGranularity(db.opts.Granularity)
+
+	if err := db.conn.Database(DB_NAME).CreateCollection(ctx, DB_TABLE_NAME,
+		options.CreateCollection().SetTimeSeriesOptions(tsOpts)); err != nil {
+		return fmt.Errorf("failed to create time-series collection: %v", err)
+	}
+
+	// Time-series collections get an index on the metaField and the timeField
+	// by default, the upserts filter on the fields inside of the metaField.
+	if _, err := db.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "meta.area", Value: 1},
+			{Key: "meta.interval", Value: 1},
+			{Key: "start_time", Value: -1},
+		}}); err != nil {
+		return err
+	}
+
+	return db.probeTimeSeriesUpsert()
+}
+
+// TimeSeriesUpsertFallback reports if the upserts into the time-series
+// collection are replaced by a delete + insert, as probed by the last Setup.
+func (db *MongoDB) TimeSeriesUpsertFallback() bool { return db.timeSeriesUpsertFallback }
+
+// probeTimeSeriesUpsert upserts a single row with the write strategy into the
+// empty time-series collection and enables the delete + insert fallback if the
+// server rejects it as unsupported. Every other error fails the setup, so the
+// benchmarks never switch to the fallback because of an unrelated error.
+func (db *MongoDB) probeTimeSeriesUpsert() error {
+	if db.opts.ForceUpsertFallback {
+		db.timeSeriesUpsertFallback = true
+		return nil
+	}
+
+	probe := newFakeObject(BaseTime, db.schema.ValueType.zero())
+	probe.Area = MONGO_UPSERT_PROBE_AREA
+
+	err := db.upsertOne(probe)
+	if err == nil {
+		// Deletes of older servers can only filter on the metaField.
+		_, err := db.coll.DeleteMany(ctx, bson.M{db.field("area"): MONGO_UPSERT_PROBE_AREA})
+		return err
+	}
+
+	if isTimeSeriesUpsertUnsupported(err) {
+		db.timeSeriesUpsertFallback = true
+		return nil
+	}
+
+	return fmt.Errorf("failed to probe the time-series upserts: %v", err)
+}
+
+// isTimeSeriesUpsertUnsupported reports if the server rejected an upsert into
+// a time-series collection as unsupported.
+func isTimeSeriesUpsertUnsupported(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+
+	if serverErr.HasErrorCode(MONGO_TIME_SERIES_UPSERT_ERROR_CODE) {
+		return true
+	}
+
+	message := strings.ToLower(serverErr.Error())
+	return serverErr.HasErrorCode(MONGO_ILLEGAL_OPERATION_ERROR_CODE) &&
+		(strings.Contains(message, "time-series") || strings.Contains(message, "time series") || strings.Contains(message, "timeseries"))
+}
+
+// field returns the path of a DataObject field in the collection.
+func (db *MongoDB) field(name string) string {
+	if db.opts.TimeSeries && (name == "area" || name == "source" || name == "interval" || name == "labels") {
+		return MONGO_META_FIELD + "." + name
+	}
+
+	return name
+}
+
+func (db *MongoDB) filter(doc DataObject) bson.M {
+	return bson.M{
+		db.field("start_time"): doc.StartTime,
+		db.field("interval"):   doc.Interval,
+		db.field("area"):       doc.Area,
+	}
+}
+
+func (db *MongoDB) document(doc DataObject) any {
+	if db.opts.TimeSeries {
+		return newMongoTimeSeriesObject(doc, db.schema)
+	}
+
+	return newMongoObject(doc, db.schema)
+}
+
+// update returns the update document of an upsert, based on the write strategy.
+func (db *MongoDB) update(doc DataObject) bson.M {
+	if db.opts.WriteStrategy == MONGO_WRITE_SET_ALL {
+		return bson.M{"$set": db.document(doc)}
+	}
+
+	// The key fields are copied from the filter when the document is inserted.
+	setOnInsert := bson.M{}
+	for _, field := range InsertOnlyFields {
+		setOnInsert[db.field(field)] = db.schema.fieldValue(doc, field)
+	}
+
+	set := bson.M{}
+	for _, field := range db.schema.updatableFields() {
+		set[db.field(field)] = db.schema.fieldValue(doc, field)
+	}
+
+	return bson.M{"$setOnInsert": setOnInsert, "$set": set}
+}
+
+func (db *MongoDB) writeModel(doc DataObject) mongo.WriteModel {
+	if db.opts.WriteStrategy == MONGO_WRITE_REPLACE {
+		return mongo.NewReplaceOneModel().SetFilter(db.filter(doc)).SetReplacement(db.document(doc)).SetUpsert(true)
+	}
+
+	return mongo.NewUpdateOneModel().SetFilter(db.filter(doc)).SetUpdate(db.update(doc)).SetUpsert(true)
+}
+
+func (db *MongoDB) upsertOne(doc DataObject) error {
+	if db.opts.WriteStrategy == MONGO_WRITE_REPLACE {
+		_, err := db.coll.ReplaceOne(ctx, db.filter(doc), db.document(doc), options.Replace().SetUpsert(true))
+		return err
+	}
+
+	_, err := db.coll.UpdateOne(ctx, db.filter(doc), db.update(doc), options.Update().SetUpsert(true))
+	return err
+}
+
 func (db *MongoDB) UpsertSingle(docs []DataObject) error {
+	if err := db.schema.checkAllValues(docs); err != nil {
+		return err
+	}
+
 	for _, doc := range docs {
-		filter := bson.M{"start_time": doc.StartTime, "interval": doc.Interval, "area": doc.Area}
-		update := bson.M{"$set": doc}
-		opt := options.Update().SetUpsert(true)
+		if db.timeSeriesUpsertFallback {
+			if err := db.replaceTimeSeries([]DataObject{doc}); err != nil {
+				return err
+			}
+			continue
+		}
 
-		if _, err := db.coll.UpdateOne(ctx, filter, update, opt); err != nil {
+		if err := db.upsertOne(doc); err != nil {
 			return err
 		}
 	}
@@ -90,18 +524,96 @@ func (db *MongoDB) UpsertSingle(docs []DataObject) error {
 }
 
 func (db *MongoDB) UpsertBulk(docs []DataObject) error {
-	var models []mongo.WriteModel
+	if err := db.schema.checkAllValues(docs); err != nil {
+		return err
+	}
 
+	if db.timeSeriesUpsertFallback {
+		return db.replaceTimeSeries(docs)
+	}
+
+	models := make([]mongo.WriteModel, 0, len(docs))
 	for _, doc := range docs {
-		filter := bson.M{"start_time": doc.StartTime, "interval": doc.Interval, "area": doc.Area}
-		update := bson.M{"$set": doc}
-		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+		models = append(models, db.writeModel(doc))
+	}
+
+	_, err := db.coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(!db.opts.UnorderedBulk))
+	return err
+}
+
+// InsertMany inserts the documents without checking for existing ones, which
+// is only valid for the initial load into an empty collection.
+func (db *MongoDB) InsertMany(docs []DataObject) error {
+	if err := db.schema.checkAllValues(docs); err != nil {
+		return err
+	}
+
+	documents := make([]any, len(docs))
+	for i, doc := range docs {
+		documents[i] = db.document(doc)
 	}
 
-	_, err := db.coll.BulkWrite(ctx, models)
+	_, err := db.coll.InsertMany(ctx, documents, options.InsertMany().SetOrdered(!db.opts.UnorderedBulk))
 	return err
 }
 
+// replaceTimeSeries is the upsert path for servers which do not support
+// upserts into time-series collections. Their deletes can only filter on the
+// metaField, so every series of the docs is read, deleted by its area and
+// interval and inserted again with the docs merged in, in order. The
+// insert-only fields are carried over from the replaced rows. As it rewrites
+// whole series, it is much slower than the upserts.
+func (db *MongoDB) replaceTimeSeries(docs []DataObject) error {
+	var keys []seriesKey
+	bySeries := make(map[seriesKey][]DataObject)
+	for _, doc := range docs {
+		key := seriesKey{Area: doc.Area, Interval: doc.Interval}
+		if _, ok := bySeries[key]; !ok {
+			keys = append(keys, key)
+		}
+		bySeries[key] = append(bySeries[key], doc)
+	}
+
+	var models []mongo.WriteModel
+	for _, key := range keys {
+		filter := bson.M{db.field("area"): key.Area, db.field("interval"): key.Interval}
+
+		cursor, err := db.coll.Find(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("time-series upsert fallback: %v", err)
+		}
+
+		var existing []mongoTimeSeriesObject
+		if err := cursor.All(ctx, &existing); err != nil {
+			return fmt.Errorf("time-series upsert fallback: %v", err)
+		}
+
+		// The rows of the series by start_time, the docs replace the
+		// existing rows with the same start_time.
+		rows := make(map[int64]mongoTimeSeriesObject, len(existing))
+		for _, obj := range existing {
+			rows[obj.StartTime.UnixMilli()] = obj
+		}
+		for _, doc := range bySeries[key] {
+			if obj, ok := rows[doc.StartTime.UnixMilli()]; ok {
+				doc.CreatedAt = obj.CreatedAt
+			}
+			rows[doc.StartTime.UnixMilli()] = newMongoTimeSeriesObject(doc, db.schema)
+		}
+
+		models = append(models, mongo.NewDeleteManyModel().SetFilter(filter))
+		for _, row := range rows {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(row))
+		}
+	}
+
+	if _, err := db.coll.BulkWrite(ctx, models); err != nil {
+		return fmt.Errorf("time-series upsert fallback: %v", err)
+	}
+
+	return nil
+}
+
 func (db *MongoDB) GetOrderedWithLimit(limit int) ([]DataObject, error) {
 	opts := options.Find().SetSort(bson.M{"start_time": -1}).SetLimit(int64(limit))
 	cursor, err := db.coll.Find(ctx, bson.M{}, opts)
@@ -109,23 +621,337 @@ func (db *MongoDB) GetOrderedWithLimit(limit int) ([]DataObject, error) {
 		return nil, err
 	}
 
+	return db.decodeAll(cursor)
+}
+
+// decodeAll decodes the documents of the cursor, which can be in the shape of
+// the time-series collection.
+func (db *MongoDB) decodeAll(cursor *mongo.Cursor) ([]DataObject, error) {
 	var results []DataObject
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		obj, err := db.decode(cursor.Decode)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, obj)
+	}
+
+	return results, cursor.Err()
+}
+
+func (db *MongoDB) StreamAll() iter.Seq2[DataObject, error] {
+	return func(yield func(DataObject, error) bool) {
+		cursor, err := db.coll.Find(ctx, bson.M{}, options.Find().SetBatchSize(MONGO_STREAM_BATCH_SIZE))
+		if err != nil {
+			yield(DataObject{}, err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			obj, err := db.decode(cursor.Decode)
+			if !yield(obj, err) || err != nil {
+				return
+			}
+		}
+
+		if err := cursor.Err(); err != nil {
+			yield(DataObject{}, err)
+		}
+	}
+}
+
+// decode decodes a single document, which can be in the shape of the
+// time-series collection.
+func (db *MongoDB) decode(decode func(val any) error) (DataObject, error) {
+	if !db.opts.TimeSeries {
+		var obj mongoObject
+		if err := decode(&obj); err != nil {
+			return DataObject{}, err
+		}
+		return obj.dataObject(db.schema)
+	}
+
+	var obj mongoTimeSeriesObject
+	if err := decode(&obj); err != nil {
+		return DataObject{}, err
+	}
+	return obj.dataObject(db.schema)
+}
+
+func (db *MongoDB) GetPageBefore(cursor *Cursor, limit int) ([]DataObject, error) {
+	filter := bson.M{}
+	if cursor != nil {
+		startTime, interval, area := db.field("start_time"), db.field("interval"), db.field("area")
+
+		filter = bson.M{"$or": bson.A{
+			bson.M{startTime: bson.M{"$lt": cursor.StartTime}},
+			bson.M{startTime: cursor.StartTime, interval: bson.M{"$lt": cursor.Interval}},
+			bson.M{startTime: cursor.StartTime, interval: cursor.Interval, area: bson.M{"$lt": cursor.Area}},
+		}}
+	}
+
+	cursorOpts := options.Find().SetSort(db.pageSort()).SetLimit(int64(limit))
+	res, err := db.coll.Find(ctx, filter, cursorOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.decodeAll(res)
+}
+
+func (db *MongoDB) GetPageOffset(offset, limit int) ([]DataObject, error) {
+	opts := options.Find().SetSort(db.pageSort()).SetSkip(int64(offset)).SetLimit(int64(limit))
+	res, err := db.coll.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.decodeAll(res)
+}
+
+func (db *MongoDB) pageSort() bson.D {
+	return bson.D{
+		{Key: db.field("start_time"), Value: -1},
+		{Key: db.field("interval"), Value: -1},
+		{Key: db.field("area"), Value: -1},
+	}
+}
+
+func (db *MongoDB) GetOne(startTime time.Time, interval int64, area string) (DataObject, error) {
+	filter := db.filter(DataObject{StartTime: startTime, Interval: interval, Area: area})
+
+	res := db.coll.FindOne(ctx, filter)
+	if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+		return DataObject{}, ErrNotFound
+	}
+
+	return db.decode(res.Decode)
+}
+
+func (db *MongoDB) GetLatestPerArea() ([]DataObject, error) {
+	area := db.field("area")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: area, Value: 1}, {Key: "start_time", Value: -1}, {Key: db.field("interval"), Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + area, "latest": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$latest"}}},
+		{{Key: "$sort", Value: bson.D{{Key: area, Value: 1}}}},
+	}
+
+	cursor, err := db.coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return db.decodeAll(cursor)
+}
+
+// GetByLabels filters on the fields of the labels subdocument. In a
+// time-series collection the labels are part of the metaField, by which the
+// buckets are grouped, so they are not indexed separately.
+func (db *MongoDB) GetByLabels(labels map[string]string, limit int) ([]DataObject, error) {
+	if !db.schema.Labels {
+		return nil, errNoLabels
+	}
+
+	filter := bson.M{}
+	for key, value := range labels {
+		filter[db.field("labels")+"."+key] = value
+	}
+
+	opts := options.Find().SetSort(bson.M{"start_time": -1}).SetLimit(int64(limit))
+	cursor, err := db.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.decodeAll(cursor)
+}
+
+func (db *MongoDB) GetAggregated(bucket Bucket, from, to time.Time) ([]AggregateObject, error) {
+	if err := bucket.validate(); err != nil {
+		return nil, err
+	}
+
+	value, err := db.aggregateValue()
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"start_time": bson.M{"$gte": from, "$lt": to}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"bucket": bson.M{"$dateTrunc": bson.M{"date": "$start_time", "unit": string(bucket)}},
+				"area":   "$" + db.field("area"),
+			},
+			"count": bson.M{"$sum": 1},
+			"min":   bson.M{"$min": value},
+			"max":   bson.M{"$max": value},
+			"avg":   bson.M{"$avg": value},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id": 0, "bucket": "$_id.bucket", "area": "$_id.area", "count": 1, "min": 1, "max": 1, "avg": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "bucket", Value: 1}, {Key: "area", Value: 1}}}},
+	}
+
+	cursor, err := db.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []AggregateObject
 	err = cursor.All(ctx, &results)
 	return results, err
 }
 
-func (db *MongoDB) TableSizeInKB() (int, error) {
-	var stats bson.M
-	command := bson.D{{Key: "collStats", Value: DB_TABLE_NAME}}
+// aggregateValue returns the expression of the value in $min, $max and $avg.
+func (db *MongoDB) aggregateValue() (any, error) {
+	switch db.schema.ValueType {
+	case VALUE_TYPE_INT64, VALUE_TYPE_BOOL:
+		return bson.M{"$toDouble": "$value"}, nil
+	case VALUE_TYPE_STRING:
+		return nil, errNotAggregatable
+	default:
+		return "$value", nil
+	}
+}
+
+func (db *MongoDB) Count() (int64, error) {
+	return db.coll.CountDocuments(ctx, bson.D{})
+}
 
-	if err := db.conn.Database(DB_NAME).RunCommand(ctx, command).Decode(&stats); err != nil {
+// TableSizeInKB returns the total size of StorageStats. The size loops of the
+// benchmarks only print the size, so sizes which didn't settle are logged as
+// a warning here.
+func (db *MongoDB) TableSizeInKB() (int, error) {
+	stats, err := db.StorageStats()
+	if err != nil {
 		return 0, err
 	}
 
-	bytes, ok := stats["totalSize"].(int32)
-	if !ok {
-		return 0, fmt.Errorf("failed to get totalSize from stats")
+	if !stats.Settled {
+		log.Printf("warning: the storage sizes of %v did not settle within %v, the size is the last one read", db.name, MONGO_STATS_TIMEOUT)
+	}
+
+	return int(stats.TotalSize / 1024), nil
+}
+
+const (
+	MONGO_STREAM_BATCH_SIZE   = 1_000
+	MONGO_STATS_POLL_INTERVAL = time.Second
+	MONGO_STATS_TIMEOUT       = 60 * time.Second
+)
+
+type MongoStorageStats struct {
+	Count       int64
+	DataSize    int64 // uncompressed size of the documents
+	StorageSize int64 // size of the documents on disk
+	IndexSize   int64 // size of all of the indexes on disk
+	TotalSize   int64 // StorageSize + IndexSize
+	// False if the sizes were still changing when MONGO_STATS_TIMEOUT passed,
+	// the sizes are the last ones read.
+	Settled bool
+}
+
+// StorageStats returns the sizes of the collection in bytes. The statistics are
+// not updated right after the writes, so a checkpoint is forced with fsync
+// first and then $collStats is polled until two consecutive reads return the
+// same sizes, or MONGO_STATS_TIMEOUT passes. The timeout is not an error, the
+// last sizes are returned with Settled false.
+func (db *MongoDB) StorageStats() (MongoStorageStats, error) {
+	if err := db.conn.Database("admin").RunCommand(ctx, bson.D{{Key: "fsync", Value: 1}}).Err(); err != nil {
+		return MongoStorageStats{}, fmt.Errorf("failed to fsync: %v", err)
+	}
+
+	deadline := time.Now().Add(MONGO_STATS_TIMEOUT)
+
+	prev, err := db.collStats()
+	if err != nil {
+		return prev, err
+	}
+
+	for time.Now().Before(deadline) {
+		time.Sleep(MONGO_STATS_POLL_INTERVAL)
+
+		stats, err := db.collStats()
+		if err != nil {
+			return stats, err
+		}
+
+		if stats == prev {
+			stats.Settled = true
+			return stats, nil
+		}
+
+		prev = stats
+	}
+
+	return prev, nil
+}
+
+func (db *MongoDB) collStats() (MongoStorageStats, error) {
+	var stats MongoStorageStats
+
+	pipeline := mongo.Pipeline{{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}}}
+	cursor, err := db.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return stats, err
 	}
 
-	return int(bytes / 1024), nil
+	var results []struct {
+		StorageStats bson.M `bson:"storageStats"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return stats, err
+	}
+
+	// Sharded collections return a document per shard.
+	for _, res := range results {
+		fields := []struct {
+			name string
+			dst  *int64
+		}{
+			{"count", &stats.Count},
+			{"size", &stats.DataSize},
+			{"storageSize", &stats.StorageSize},
+			{"totalIndexSize", &stats.IndexSize},
+		}
+
+		for _, f := range fields {
+			n, err := bsonNumberToInt64(res.StorageStats[f.name])
+			if err != nil {
+				return stats, fmt.Errorf("failed to read %v from storageStats: %v", f.name, err)
+			}
+			*f.dst += n
+		}
+	}
+
+	stats.TotalSize = stats.StorageSize + stats.IndexSize
+	return stats, nil
+}
+
+// bsonNumberToInt64 converts any of the numeric bson types to an int64. The
+// server picks the type based on the size of the value, so e.g. totalSize is
+// an int32 for small collections and an int64 or double for larger ones.
+func bsonNumberToInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		return int64(f), err
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unexpected type %T", v)
+	}
 }
@@ -2,16 +2,56 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
+	"maps"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type MySQLDB struct {
-	conn *sql.DB
-	name string
+	conn   *sql.DB
+	name   string
+	opts   MySQLOptions
+	schema Schema
+	series seriesCache
+}
+
+type MySQLOptions struct {
+	// The labels of Schema.Labels with a functional index, as mysql can only
+	// index the values of known JSON paths. GetByLabels filters any other label
+	// with JSON_CONTAINS, without an index. nil uses the default, an empty
+	// slice creates no index.
+	IndexedLabels []string
+}
+
+// The default options index the keys of FakeLabels.
+var DefaultMySQLOptions = MySQLOptions{
+	IndexedLabels: []string{"device", "region", "kind"},
+}
+
+// MYSQL_MAX_LABEL_LENGTH is the maximum length of the value of an indexed
+// label. The functional indexes cast the values to a CHAR of this length, so
+// the upserts reject longer values instead of letting the cast truncate them.
+const MYSQL_MAX_LABEL_LENGTH = 50
+
+// The keys of the indexed labels are part of the JSON paths and index names.
+var mysqlLabelKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func (o MySQLOptions) validate() error {
+	for _, key := range o.IndexedLabels {
+		if !mysqlLabelKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid indexed label %q, must match %v", key, mysqlLabelKeyPattern)
+		}
+	}
+
+	return nil
 }
 
 // mysql -u test -p -h localhost -P 5554
@@ -31,22 +71,105 @@ func NewMySQLDB(name, host string, port int, username, password, dbname string)
 	conn.SetConnMaxLifetime(time.Hour)
 
 	return &MySQLDB{
-		name: name,
-		conn: conn,
+		name:   name,
+		conn:   conn,
+		opts:   DefaultMySQLOptions,
+		schema: DefaultSchema,
 	}, nil
 }
 
+// SetOptions changes the options used by the next call of Setup.
+func (db *MySQLDB) SetOptions(opts MySQLOptions) {
+	if opts.IndexedLabels == nil {
+		opts.IndexedLabels = DefaultMySQLOptions.IndexedLabels
+	}
+
+	db.opts = opts
+}
+
+func (db *MySQLDB) Options() MySQLOptions { return db.opts }
+
 func (db *MySQLDB) GetName() string {
 	return db.name
 }
 
-func (db *MySQLDB) Setup() error {
+func (db *MySQLDB) SetSchema(schema Schema) error {
+	schema, err := schema.withDefaults()
+	if err != nil {
+		return err
+	}
 
-	_, err := db.conn.ExecContext(ctx, `DROP TABLE IF EXISTS `+DB_TABLE_NAME)
+	db.schema = schema
+	db.series = seriesCache{}
+	return nil
+}
+
+var mysqlValueTypes = map[ValueType]string{
+	VALUE_TYPE_FLOAT64: "DOUBLE",
+	VALUE_TYPE_INT64:   "BIGINT",
+	VALUE_TYPE_BOOL:    "BOOLEAN",
+	VALUE_TYPE_STRING:  "VARCHAR(50)",
+}
+
+// BOOLEAN is reported as TINYINT by information_schema.columns.
+var mysqlColumnValueTypes = map[string]ValueType{
+	"double":  VALUE_TYPE_FLOAT64,
+	"bigint":  VALUE_TYPE_INT64,
+	"tinyint": VALUE_TYPE_BOOL,
+	"varchar": VALUE_TYPE_STRING,
+}
+
+func (db *MySQLDB) DetectSchema() (Schema, error) {
+	columns, err := sqlColumns(db.conn, `
+		SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ?`, DB_TABLE_NAME)
 	if err != nil {
+		return Schema{}, err
+	}
+
+	var normalized bool
+	err = db.conn.QueryRowContext(ctx, `
+		SELECT count(*) > 0 FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_name = ?`, DATA_POINTS_TABLE_NAME).Scan(&normalized)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	return schemaOfColumns(columns, mysqlColumnValueTypes, normalized)
+}
+
+func (db *MySQLDB) Setup() error {
+	if err := db.opts.validate(); err != nil {
+		return err
+	}
+
+	var tableType string
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT table_type FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_name = ?`, DB_TABLE_NAME).Scan(&tableType)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return err
 	}
 
+	for _, query := range []string{
+		dropDataObjects(tableType),
+		`DROP TABLE IF EXISTS ` + DATA_POINTS_TABLE_NAME,
+		`DROP TABLE IF EXISTS ` + SERIES_TABLE_NAME,
+	} {
+		if _, err := db.conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	db.series = seriesCache{}
+	if db.schema.Normalized {
+		if err := db.setupNormalized(); err != nil {
+			return err
+		}
+
+		return db.createLabelIndexes()
+	}
+
 	_, err = db.conn.ExecContext(ctx, fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %v (
 			created_at  DATETIME 			NOT NULL,
@@ -55,10 +178,10 @@ func (db *MySQLDB) Setup() error {
 			resolution  BIGINT    			NOT NULL,
 			area        VARCHAR(50)      	NOT NULL,
 			source      VARCHAR(50)      	NOT NULL,
-			value       DOUBLE    			NOT NULL,
+			%v,
 			PRIMARY KEY (start_time, resolution, area(50))
 		)
-	`, DB_TABLE_NAME))
+	`, DB_TABLE_NAME, db.schema.columnDefinitions(mysqlValueTypes[db.schema.ValueType], "JSON")))
 	if err != nil {
 		return err
 	}
@@ -68,22 +191,119 @@ func (db *MySQLDB) Setup() error {
 		return fmt.Errorf("failed to create index: %v", err)
 	}
 
+	return db.createLabelIndexes()
+}
+
+// mysqlLabelExpr returns the expression of the functional index of the label.
+// The index is only used by a query with the exact same expression.
+func mysqlLabelExpr(key string) string {
+	return fmt.Sprintf(`(CAST(labels->>'$.%v' AS CHAR(%v)) COLLATE utf8mb4_bin)`, key, MYSQL_MAX_LABEL_LENGTH)
+}
+
+// createLabelIndexes creates a functional index on every label of
+// MySQLOptions.IndexedLabels.
+func (db *MySQLDB) createLabelIndexes() error {
+	if !db.schema.Labels {
+		return nil
+	}
+
+	for _, key := range db.opts.IndexedLabels {
+		if _, err := db.conn.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX idx_labels_%v ON %v (%v)`,
+			key, db.schema.dataTable(), mysqlLabelExpr(key))); err != nil {
+			return fmt.Errorf("failed to create the index of the label %v: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *MySQLDB) setupNormalized() error {
+	for _, query := range []string{
+		fmt.Sprintf(`
+			CREATE TABLE %v (
+				id          BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				area        VARCHAR(50)  NOT NULL,
+				source      VARCHAR(50)  NOT NULL,
+				resolution  BIGINT       NOT NULL,
+				UNIQUE (area, resolution)
+			)`, SERIES_TABLE_NAME),
+		fmt.Sprintf(`
+			CREATE TABLE %v (
+				series_id   BIGINT    NOT NULL,
+				created_at  DATETIME  NOT NULL,
+				updated_at  DATETIME  NOT NULL,
+				start_time  DATETIME  NOT NULL,
+				%v,
+				PRIMARY KEY (series_id, start_time),
+				FOREIGN KEY (series_id) REFERENCES %v (id)
+			)`, DATA_POINTS_TABLE_NAME, db.schema.columnDefinitions(mysqlValueTypes[db.schema.ValueType], "JSON"), SERIES_TABLE_NAME),
+		fmt.Sprintf(`CREATE INDEX idx_start_time ON %v (start_time)`, DATA_POINTS_TABLE_NAME),
+		`CREATE VIEW ` + DB_TABLE_NAME + ` AS ` + db.schema.normalizedView("resolution"),
+	} {
+		if _, err := db.conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// upsertSeries writes the series of the doc and returns its id. LAST_INSERT_ID
+// makes mysql return the id of an existing series as well.
+func (db *MySQLDB) upsertSeries(doc DataObject) (int64, error) {
+	result, err := db.conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %v (area, source, resolution) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), source = VALUES(source)`, SERIES_TABLE_NAME),
+		doc.Area, doc.Source, doc.Interval)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (db *MySQLDB) upsertQuery() string {
+	return fmt.Sprintf(`
+		INSERT INTO %v (%v)
+		VALUES (%v)
+		ON DUPLICATE KEY UPDATE %v
+	`, db.schema.dataTable(), db.schema.writeColumns("resolution"), db.schema.placeholders(false), db.schema.upsertSetClause("%[1]v = VALUES(%[1]v)"))
+}
+
 func (db *MySQLDB) Close() error { return db.conn.Close() }
 
+// checkLabels rejects the docs with an indexed label longer than
+// MYSQL_MAX_LABEL_LENGTH.
+func (db *MySQLDB) checkLabels(docs []DataObject) error {
+	if !db.schema.Labels {
+		return nil
+	}
+
+	for _, doc := range docs {
+		for _, key := range db.opts.IndexedLabels {
+			if value := doc.Labels[key]; utf8.RuneCountInString(value) > MYSQL_MAX_LABEL_LENGTH {
+				return fmt.Errorf("the label %v of %v at %v is longer than %v characters", key, doc.Area, doc.StartTime, MYSQL_MAX_LABEL_LENGTH)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (db *MySQLDB) UpsertSingle(docs []DataObject) error {
-	query := fmt.Sprintf(`
-		INSERT INTO %v (created_at, updated_at, start_time, resolution, area, source, value)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		updated_at = VALUES(updated_at), source = VALUES(source), value = VALUES(value)
-	`, DB_TABLE_NAME)
+	if err := db.checkLabels(docs); err != nil {
+		return fmt.Errorf("UpsertSingle: %v", err)
+	}
+
+	query := db.upsertQuery()
 
 	for _, doc := range docs {
-		_, err := db.conn.Exec(query,
-			doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source, doc.Value)
+		values, err := db.schema.writeValues([]DataObject{doc}, db.series, db.upsertSeries)
+		if err != nil {
+			return fmt.Errorf("UpsertSingle: %v", err)
+		}
+
+		_, err = db.conn.Exec(query, values[0]...)
 		if err != nil {
 			return fmt.Errorf("UpsertSingle: %v", err)
 		}
@@ -93,26 +313,28 @@ func (db *MySQLDB) UpsertSingle(docs []DataObject) error {
 }
 
 func (db *MySQLDB) UpsertBulk(docs []DataObject) error {
+	if err := db.checkLabels(docs); err != nil {
+		return fmt.Errorf("UpsertBulk: %v", err)
+	}
+
+	rows, err := db.schema.writeValues(docs, db.series, db.upsertSeries)
+	if err != nil {
+		return fmt.Errorf("UpsertBulk: %v", err)
+	}
 
 	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("UpsertBulk: %v", err)
 	}
 
-	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`
-		INSERT INTO %v (created_at, updated_at, start_time, resolution, area, source, value)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		updated_at = VALUES(updated_at), source = VALUES(source), value = VALUES(value)
-	`, DB_TABLE_NAME))
+	stmt, err := tx.PrepareContext(ctx, db.upsertQuery())
 	if err != nil {
 		return fmt.Errorf("UpsertBulk: %v", err)
 	}
 	defer stmt.Close()
 
-	for _, doc := range docs {
-		_, err = stmt.Exec(
-			doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source, doc.Value)
+	for _, values := range rows {
+		_, err = stmt.Exec(values...)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("UpsertBulk: %v", err)
@@ -136,10 +358,98 @@ func (db *MySQLDB) GetOrderedWithLimit(limit int) ([]DataObject, error) {
 	}
 	defer rows.Close()
 
+	results := make([]DataObject, 0, limit)
+	for rows.Next() {
+		obj, err := scanDataObject(rows, db.schema)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, obj)
+	}
+
+	return results, rows.Err()
+}
+
+func (db *MySQLDB) StreamAll() iter.Seq2[DataObject, error] {
+	return func(yield func(DataObject, error) bool) {
+		query := fmt.Sprintf(`SELECT %v FROM %v`, db.schema.columns("resolution"), DB_TABLE_NAME)
+
+		rows, err := db.conn.QueryContext(ctx, query)
+		if err != nil {
+			yield(DataObject{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			obj, err := scanDataObject(rows, db.schema)
+			if !yield(obj, err) || err != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(DataObject{}, err)
+		}
+	}
+}
+
+func (db *MySQLDB) GetOne(startTime time.Time, interval int64, area string) (DataObject, error) {
+	query := fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE start_time = ? AND resolution = ? AND area = ?`, db.schema.columns("resolution"), DB_TABLE_NAME)
+
+	obj, err := scanDataObject(db.conn.QueryRowContext(ctx, query, startTime, interval, area), db.schema)
+	if errors.Is(err, sql.ErrNoRows) {
+		return obj, ErrNotFound
+	}
+
+	return obj, err
+}
+
+func (db *MySQLDB) GetLatestPerArea() ([]DataObject, error) {
+	query := fmt.Sprintf(`
+		SELECT %v FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY area ORDER BY start_time DESC, resolution DESC) AS row_num FROM %v
+		) latest
+		WHERE row_num = 1 ORDER BY area`, db.schema.columns("resolution"), DB_TABLE_NAME)
+
+	return db.queryDataObjects(query)
+}
+
+func (db *MySQLDB) GetPageBefore(cursor *Cursor, limit int) ([]DataObject, error) {
+	if cursor == nil {
+		return db.queryDataObjects(fmt.Sprintf(`
+			SELECT %v FROM %v
+			ORDER BY start_time DESC, resolution DESC, area DESC LIMIT ?`, db.schema.columns("resolution"), DB_TABLE_NAME), limit)
+	}
+
+	// mysql doesn't reliably use a range scan of the primary key for a row
+	// comparison, especially with the prefix of area, so it is expanded.
+	return db.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE start_time < ? OR (start_time = ? AND (resolution < ? OR (resolution = ? AND area < ?)))
+		ORDER BY start_time DESC, resolution DESC, area DESC LIMIT ?`, db.schema.columns("resolution"), DB_TABLE_NAME),
+		cursor.StartTime, cursor.StartTime, cursor.Interval, cursor.Interval, cursor.Area, limit)
+}
+
+func (db *MySQLDB) GetPageOffset(offset, limit int) ([]DataObject, error) {
+	return db.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		ORDER BY start_time DESC, resolution DESC, area DESC LIMIT ? OFFSET ?`, db.schema.columns("resolution"), DB_TABLE_NAME), limit, offset)
+}
+
+func (db *MySQLDB) queryDataObjects(query string, args ...any) ([]DataObject, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
 	var results []DataObject
 	for rows.Next() {
-		var obj DataObject
-		err := rows.Scan(&obj.CreatedAt, &obj.UpdatedAt, &obj.StartTime, &obj.Interval, &obj.Area, &obj.Source, &obj.Value)
+		obj, err := scanDataObject(rows, db.schema)
 		if err != nil {
 			return nil, err
 		}
@@ -147,14 +457,91 @@ func (db *MySQLDB) GetOrderedWithLimit(limit int) ([]DataObject, error) {
 		results = append(results, obj)
 	}
 
-	return results, nil
+	return results, rows.Err()
+}
+
+// GetByLabels filters the labels of MySQLOptions.IndexedLabels with the
+// expressions of their functional indexes, and the other labels with JSON_CONTAINS.
+func (db *MySQLDB) GetByLabels(labels map[string]string, limit int) ([]DataObject, error) {
+	if !db.schema.Labels {
+		return nil, errNoLabels
+	}
+
+	var (
+		conditions []string
+		args       []any
+	)
+	other := maps.Clone(labels)
+	for _, key := range db.opts.IndexedLabels {
+		if value, ok := labels[key]; ok {
+			conditions = append(conditions, mysqlLabelExpr(key)+" = ?")
+			args = append(args, value)
+			delete(other, key)
+		}
+	}
+	if len(other) > 0 || len(conditions) == 0 {
+		conditions = append(conditions, "JSON_CONTAINS(labels, ?)")
+		args = append(args, encodeLabels(other))
+	}
+
+	return db.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE %v
+		ORDER BY start_time DESC LIMIT ?`, db.schema.columns("resolution"), DB_TABLE_NAME, strings.Join(conditions, " AND ")), append(args, limit)...)
+}
+
+func (db *MySQLDB) GetAggregated(bucket Bucket, from, to time.Time) ([]AggregateObject, error) {
+	if err := bucket.validate(); err != nil {
+		return nil, err
+	}
+
+	// BOOLEAN is a TINYINT in mysql, so no cast is needed.
+	value, err := db.schema.ValueType.aggregateExpr("value", "%v")
+	if err != nil {
+		return nil, err
+	}
+
+	// There is no date_trunc in mysql, so the buckets are built from the date.
+	bucketExpr := `CAST(DATE(start_time) AS DATETIME)`
+	if bucket == BUCKET_HOUR {
+		bucketExpr = `DATE_ADD(CAST(DATE(start_time) AS DATETIME), INTERVAL HOUR(start_time) HOUR)`
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %v AS bucket, area, COUNT(*), MIN(%[3]v), MAX(%[3]v), AVG(%[3]v)
+		FROM %[2]v WHERE start_time >= ? AND start_time < ?
+		GROUP BY bucket, area ORDER BY bucket, area`, bucketExpr, DB_TABLE_NAME, value)
+
+	rows, err := db.conn.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []AggregateObject
+	for rows.Next() {
+		var obj AggregateObject
+		if err := rows.Scan(&obj.Bucket, &obj.Area, &obj.Count, &obj.Min, &obj.Max, &obj.Avg); err != nil {
+			return nil, err
+		}
+
+		results = append(results, obj)
+	}
+
+	return results, rows.Err()
+}
+
+func (db *MySQLDB) Count() (int64, error) {
+	var count int64
+	err := db.conn.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %v`, DB_TABLE_NAME)).Scan(&count)
+	return count, err
 }
 
 func (db *MySQLDB) TableSizeInKB() (int, error) {
 
 	var totalSize string
 
-	query := fmt.Sprintf(`SELECT ROUND(SUM(data_length + index_length) / 1024) AS total_size FROM information_schema.TABLES WHERE table_name = '%v' AND table_schema = DATABASE();`, DB_TABLE_NAME)
+	query := fmt.Sprintf(`SELECT ROUND(SUM(data_length + index_length) / 1024) AS total_size FROM information_schema.TABLES WHERE table_name IN (%v) AND table_schema = DATABASE();`, formatFields(db.schema.storageTables(), "'%v'"))
 	err := db.conn.QueryRowContext(ctx, query).Scan(&totalSize)
 	if err != nil {
 		return 0, err
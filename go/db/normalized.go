@@ -0,0 +1,122 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The tables of the normalized schema. data_objects is a view which joins them
+// back together, so the reads work the same for every schema.
+const (
+	SERIES_TABLE_NAME      = "series"
+	DATA_POINTS_TABLE_NAME = "data_points"
+)
+
+// dataTable returns the table which holds the rows: data_points in the
+// normalized schema and data_objects otherwise.
+func (s Schema) dataTable() string {
+	if s.Normalized {
+		return DATA_POINTS_TABLE_NAME
+	}
+
+	return DB_TABLE_NAME
+}
+
+// storageTables returns the tables which count towards the storage size.
+func (s Schema) storageTables() []string {
+	if s.Normalized {
+		return []string{DATA_POINTS_TABLE_NAME, SERIES_TABLE_NAME}
+	}
+
+	return []string{DB_TABLE_NAME}
+}
+
+// writeColumns returns the comma separated columns of the upserts into
+// dataTable.
+func (s Schema) writeColumns(interval string) string {
+	return strings.Join(s.writeColumnNames(interval), ", ")
+}
+
+// writeColumnNames returns the columns of the upserts. The normalized columns
+// are in the order of the rows of writeValues.
+func (s Schema) writeColumnNames(interval string) []string {
+	if s.Normalized {
+		return append([]string{"series_id", "created_at", "updated_at", "start_time"}, s.dataColumns()...)
+	}
+
+	return s.columnNames(interval)
+}
+
+// normalizedView returns the query of the data_objects view, which joins the
+// data points with their series.
+func (s Schema) normalizedView(interval string) string {
+	return fmt.Sprintf(`
+		SELECT p.created_at, p.updated_at, p.start_time, s.%v, s.area, s.source, %v
+		FROM %v p JOIN %v s ON s.id = p.series_id`,
+		interval, formatFields(s.dataColumns(), "p.%v"), DATA_POINTS_TABLE_NAME, SERIES_TABLE_NAME)
+}
+
+// dropDataObjects returns the statement which drops data_objects, which is a
+// view in the normalized schema and a table otherwise. tableType is the
+// table_type of information_schema.tables, empty if it does not exist.
+func dropDataObjects(tableType string) string {
+	if tableType == "VIEW" {
+		return `DROP VIEW IF EXISTS ` + DB_TABLE_NAME
+	}
+
+	return `DROP TABLE IF EXISTS ` + DB_TABLE_NAME
+}
+
+type seriesKey struct {
+	Area     string
+	Interval int64
+}
+
+type seriesEntry struct {
+	ID     int64
+	Source string
+}
+
+// seriesCache holds the ids of the series which were already written, so the
+// upserts only write a series when it is new or its source changed. It is
+// reset by SetSchema as well as Setup, as a table can be written without Setup
+// (e.g. by a resumed migration).
+type seriesCache map[seriesKey]seriesEntry
+
+// writeValues returns the values of the upserts of the docs in the order of
+// writeColumns. In the normalized schema the series of the docs are resolved
+// first, upsertSeries creates or updates a series and returns its id. The bulk
+// upserts call it before they start the transaction or batch of the data
+// points, so the series are written outside of it.
+func (s Schema) writeValues(docs []DataObject, cache seriesCache, upsertSeries func(doc DataObject) (int64, error)) ([][]any, error) {
+	rows := make([][]any, len(docs))
+
+	if err := s.checkAllValues(docs); err != nil {
+		return nil, err
+	}
+
+	for i, doc := range docs {
+		if !s.Normalized {
+			rows[i] = s.values(doc)
+			continue
+		}
+
+		key := seriesKey{Area: doc.Area, Interval: doc.Interval}
+		series, ok := cache[key]
+		if !ok || series.Source != doc.Source {
+			id, err := upsertSeries(doc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write the series of %v: %v", doc.Area, err)
+			}
+
+			series = seriesEntry{ID: id, Source: doc.Source}
+			cache[key] = series
+		}
+
+		row := make([]any, 0, 5+s.Fields)
+		row = append(row, series.ID, doc.CreatedAt, doc.UpdatedAt, doc.StartTime)
+		rows[i] = s.appendDataValues(row, doc)
+	}
+
+	return rows, nil
+}
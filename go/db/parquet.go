@@ -0,0 +1,366 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ParquetPartition is the time range of the rows in a single parquet file.
+type ParquetPartition string
+
+const (
+	PARQUET_PARTITION_DAY   ParquetPartition = "day"
+	PARQUET_PARTITION_MONTH ParquetPartition = "month"
+
+	PARQUET_FILE_NAME     = "data.parquet"
+	PARQUET_STAGING_TABLE = "parquet_staging"
+)
+
+func (p ParquetPartition) validate() error {
+	switch p {
+	case PARQUET_PARTITION_DAY, PARQUET_PARTITION_MONTH:
+		return nil
+	default:
+		return fmt.Errorf("unknown parquet partition: %v", p)
+	}
+}
+
+// start returns the start of the partition which contains t.
+func (p ParquetPartition) start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == PARQUET_PARTITION_MONTH {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// end returns the (exclusive) end of the partition which starts at start.
+func (p ParquetPartition) end(start time.Time) time.Time {
+	if p == PARQUET_PARTITION_MONTH {
+		return start.AddDate(0, 1, 0)
+	}
+
+	return start.AddDate(0, 0, 1)
+}
+
+// dirName returns the hive style directory name of the partition, e.g.
+// day=2021-01-01 or month=2021-01.
+func (p ParquetPartition) dirName(start time.Time) string {
+	if p == PARQUET_PARTITION_MONTH {
+		return fmt.Sprintf("%v=%v", p, start.Format("2006-01"))
+	}
+
+	return fmt.Sprintf("%v=%v", p, start.Format("2006-01-02"))
+}
+
+// ParquetDB is the archive tier: the rows are written to parquet files, one
+// file per partition, and read with read_parquet through an in memory duckdb.
+// All of the reads are the ones of DuckDB, on a view over the files.
+//
+// Parquet files can not be modified, so every upsert rewrites the files of the
+// partitions it touches. The rows of a partition are merged with the upsert
+// semantics before the file is replaced.
+type ParquetDB struct {
+	*DuckDB
+	dir       string
+	partition ParquetPartition
+	hasFiles  bool
+}
+
+func NewParquetDB(name, dir string, partition ParquetPartition) (*ParquetDB, error) {
+	if err := partition.validate(); err != nil {
+		return nil, err
+	}
+
+	duckDb, err := NewDuckDB(name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ParquetDB{
+		DuckDB:    duckDb,
+		dir:       dir,
+		partition: partition,
+	}
+
+	// The files of a previous run can be read without a Setup.
+	if err := p.createView(); err != nil {
+		duckDb.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// SetSchema rejects the normalized schema, the files always hold the
+// denormalized rows. The view over the existing files is replaced with the
+// columns of the schema.
+func (p *ParquetDB) SetSchema(schema Schema) error {
+	if schema.Normalized {
+		return errNormalizedUnsupported
+	}
+
+	if err := p.DuckDB.SetSchema(schema); err != nil {
+		return err
+	}
+
+	return p.createView()
+}
+
+// Setup removes every parquet file in the directory.
+func (p *ParquetDB) Setup() error {
+	if err := os.RemoveAll(p.dir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return err
+	}
+
+	return p.createView()
+}
+
+// DetectSchema reads the columns of the parquet files rather than of the
+// view, which only holds the columns of the current schema. Without files
+// the current schema is returned.
+func (p *ParquetDB) DetectSchema() (Schema, error) {
+	files, err := filepath.Glob(p.glob())
+	if err != nil {
+		return Schema{}, err
+	}
+	if len(files) == 0 {
+		return p.schema, nil
+	}
+
+	columns, err := sqlColumns(p.db, fmt.Sprintf(`SELECT column_name, column_type FROM (DESCRIBE SELECT * FROM read_parquet(%v, hive_partitioning = false))`, sqlString(p.glob())))
+	if err != nil {
+		return Schema{}, err
+	}
+
+	return schemaOfColumns(columns, duckdbColumnValueTypes, false)
+}
+
+// createView points the data_objects view at the parquet files. read_parquet
+// fails when there are no files, so the view is empty until the first write.
+// The glob of the view is expanded on every query, so it only has to be
+// replaced once.
+func (p *ParquetDB) createView() error {
+	files, err := filepath.Glob(p.glob())
+	if err != nil {
+		return err
+	}
+	p.hasFiles = len(files) > 0
+
+	values := formatFields(p.schema.valueColumns(), "NULL::"+duckdbValueTypes[p.schema.ValueType]+" AS %v")
+	if p.schema.Labels {
+		values += ", NULL::JSON AS labels"
+	}
+
+	source := fmt.Sprintf(`
+		SELECT NULL::TIMESTAMP AS created_at, NULL::TIMESTAMP AS updated_at, NULL::TIMESTAMP AS start_time,
+			NULL::BIGINT AS interval, NULL::TEXT AS area, NULL::TEXT AS source, %v
+		WHERE false`, values)
+	if p.hasFiles {
+		source = fmt.Sprintf(`
+			SELECT %v
+			FROM read_parquet(%v, hive_partitioning = false)`, p.schema.columns("interval"), sqlString(p.glob()))
+	}
+
+	_, err = p.db.Exec(fmt.Sprintf(`CREATE OR REPLACE VIEW %v AS %v`, DB_TABLE_NAME, source))
+	return err
+}
+
+func (p *ParquetDB) glob() string {
+	return filepath.Join(p.dir, "*", PARQUET_FILE_NAME)
+}
+
+func (p *ParquetDB) UpsertSingle(docs []DataObject) error {
+	for _, doc := range docs {
+		if err := p.write([]DataObject{doc}); err != nil {
+			return fmt.Errorf("UpsertSingle: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *ParquetDB) UpsertBulk(docs []DataObject) error {
+	if err := p.write(docs); err != nil {
+		return fmt.Errorf("UpsertBulk: %v", err)
+	}
+
+	return nil
+}
+
+// write loads the docs into the staging table, merges the rows of the existing
+// files of the touched partitions into it and rewrites those files.
+func (p *ParquetDB) write(docs []DataObject) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	if err := p.schema.checkAllValues(docs); err != nil {
+		return err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		CREATE OR REPLACE TABLE %v (
+			created_at  TIMESTAMP NOT NULL,
+			updated_at  TIMESTAMP NOT NULL,
+			start_time  TIMESTAMP NOT NULL,
+			interval    BIGINT    NOT NULL,
+			area        TEXT      NOT NULL,
+			source      TEXT      NOT NULL,
+			%v,
+			UNIQUE(start_time, interval, area)
+		);
+	`, PARQUET_STAGING_TABLE, p.schema.columnDefinitions(duckdbValueTypes[p.schema.ValueType], "JSON")))
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+		INSERT INTO %v (%v)
+		VALUES (%v)
+		ON CONFLICT(%v) DO UPDATE SET %v;
+	`, PARQUET_STAGING_TABLE, p.schema.columns("interval"), p.schema.placeholders(false), p.schema.upsertConflictTarget(), p.schema.upsertSetClause("%[1]v = EXCLUDED.%[1]v")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	partitions := make(map[time.Time]struct{})
+	for _, doc := range docs {
+		if _, err := stmt.Exec(p.schema.values(doc)...); err != nil {
+			return err
+		}
+
+		partitions[p.partition.start(doc.StartTime)] = struct{}{}
+	}
+
+	for start := range partitions {
+		path := p.filePath(start)
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		// The existing rows are merged into the new ones, so a conflict keeps
+		// the insert-only fields of the existing row.
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO %v SELECT %v
+			FROM read_parquet(%v)
+			ON CONFLICT(%v) DO UPDATE SET %v;
+		`, PARQUET_STAGING_TABLE, p.schema.columns("interval"), sqlString(path), p.schema.upsertConflictTarget(),
+			formatFields(InsertOnlyFields, "%[1]v = EXCLUDED.%[1]v")))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The files are only replaced once every partition was copied and the
+	// transaction was committed, so a failed write keeps all of the old files.
+	paths := make([]string, 0, len(partitions))
+	defer func() {
+		// Only the temporary files which weren't renamed are left.
+		for _, path := range paths {
+			os.Remove(path + ".tmp")
+		}
+	}()
+
+	for start := range partitions {
+		path := p.filePath(start)
+		paths = append(paths, path)
+		if err := p.writePartition(tx, path, start); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+
+	if p.hasFiles {
+		return nil
+	}
+
+	return p.createView()
+}
+
+// writePartition copies the staged rows of the partition into a temporary file
+// next to its path, which write renames over the old file, so readers never see
+// a partial file.
+func (p *ParquetDB) writePartition(tx *sql.Tx, path string, start time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(fmt.Sprintf(`
+		COPY (
+			SELECT %v FROM %v
+			WHERE start_time >= %v AND start_time < %v
+			ORDER BY start_time, interval, area
+		) TO %v (FORMAT PARQUET)
+	`, p.schema.columns("interval"), PARQUET_STAGING_TABLE, sqlTimestamp(start), sqlTimestamp(p.partition.end(start)), sqlString(path+".tmp")))
+	return err
+}
+
+func (p *ParquetDB) filePath(start time.Time) string {
+	return filepath.Join(p.dir, p.partition.dirName(start), PARQUET_FILE_NAME)
+}
+
+// TableSizeInKB returns the size of all of the parquet files.
+func (p *ParquetDB) TableSizeInKB() (int, error) {
+	var total int64
+	err := filepath.WalkDir(p.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != ".parquet" {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(total / 1024), nil
+}
+
+// sqlString quotes s as a string literal, for the statements which can not
+// take parameters (file paths of read_parquet and COPY).
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func sqlTimestamp(t time.Time) string {
+	return fmt.Sprintf("TIMESTAMP '%v'", t.UTC().Format("2006-01-02 15:04:05"))
+}
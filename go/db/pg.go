@@ -2,8 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -12,6 +16,65 @@ type PostgresDB struct {
 	conn           *pgx.Conn
 	usingTimescale bool
 	name           string
+	opts           PostgresOptions
+	schema         Schema
+	series         seriesCache
+}
+
+type PostgresOptions struct {
+	// Timescale only. Width of a single chunk of the hypertable, e.g. "60 days".
+	ChunkInterval string
+	// Timescale only. Value of timescaledb.compress_segmentby, e.g. "area".
+	// The default of timescale is used if empty.
+	CompressSegmentBy string
+	// Timescale only. Value of timescaledb.compress_orderby, e.g. "start_time DESC".
+	// The default of timescale is used if empty.
+	CompressOrderBy string
+	// Timescale only. Creates hourly and daily continuous aggregates over the
+	// table, which are then used by GetAggregated. They are not refreshed
+	// automatically, see RefreshContinuousAggregates. Not supported with the
+	// normalized schema.
+	ContinuousAggregates bool
+	// Native postgres only. Creates the table with declarative partitioning by
+	// range of start_time, using partitions of this width. Zero disables it.
+	PartitionWidth time.Duration
+	// Native postgres only. Range for which the partitions are created in Setup.
+	// Rows outside of it end up in the default partition.
+	PartitionFrom time.Time
+	PartitionTo   time.Time
+	// Indexes created in Setup on top of the (start_time, interval, area)
+	// primary key. Not supported with the normalized schema.
+	Indexes []PostgresIndex
+}
+
+type PostgresIndex string
+
+const (
+	INDEX_BRIN_START_TIME     PostgresIndex = "brin-start-time"
+	INDEX_START_TIME_DESC     PostgresIndex = "btree-start-time-desc"
+	INDEX_AREA_START_TIME     PostgresIndex = "btree-area-start-time"
+	INDEX_COVERING_AREA_VALUE PostgresIndex = "btree-area-start-time-include-value"
+)
+
+func (idx PostgresIndex) statement() (string, error) {
+	switch idx {
+	case INDEX_BRIN_START_TIME:
+		return fmt.Sprintf(`CREATE INDEX %v_start_time_brin ON %v USING brin (start_time)`, DB_TABLE_NAME, DB_TABLE_NAME), nil
+	case INDEX_START_TIME_DESC:
+		return fmt.Sprintf(`CREATE INDEX %v_start_time_desc ON %v (start_time DESC)`, DB_TABLE_NAME, DB_TABLE_NAME), nil
+	case INDEX_AREA_START_TIME:
+		return fmt.Sprintf(`CREATE INDEX %v_area_start_time ON %v (area, start_time)`, DB_TABLE_NAME, DB_TABLE_NAME), nil
+	case INDEX_COVERING_AREA_VALUE:
+		return fmt.Sprintf(`CREATE INDEX %v_area_start_time_covering ON %v (area, start_time DESC) INCLUDE (value)`, DB_TABLE_NAME, DB_TABLE_NAME), nil
+	default:
+		return "", fmt.Errorf("unknown index: %q", idx)
+	}
+}
+
+// The default chunk interval compresses the hourly data of the benchmarks much
+// better than the 7 days default of timescale (see the README).
+var DefaultPostgresOptions = PostgresOptions{
+	ChunkInterval: "60 days",
 }
 
 func NewPostgresDB(name, host string, port int, username, password, dbname string, usingTimescale bool) (*PostgresDB, error) {
@@ -25,19 +88,127 @@ func NewPostgresDB(name, host string, port int, username, password, dbname strin
 		name:           name,
 		conn:           conn,
 		usingTimescale: usingTimescale,
+		opts:           DefaultPostgresOptions,
+		schema:         DefaultSchema,
 	}, nil
 }
 
+// SetOptions changes the options used by the next call of Setup.
+func (db *PostgresDB) SetOptions(opts PostgresOptions) {
+	if opts.ChunkInterval == "" {
+		opts.ChunkInterval = DefaultPostgresOptions.ChunkInterval
+	}
+
+	db.opts = opts
+}
+
+func (db *PostgresDB) Options() PostgresOptions { return db.opts }
+
+func (db *PostgresDB) SetSchema(schema Schema) error {
+	schema, err := schema.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	db.schema = schema
+	db.series = seriesCache{}
+	return nil
+}
+
+var pgValueTypes = map[ValueType]string{
+	VALUE_TYPE_FLOAT64: "DOUBLE PRECISION",
+	VALUE_TYPE_INT64:   "BIGINT",
+	VALUE_TYPE_BOOL:    "BOOLEAN",
+	VALUE_TYPE_STRING:  "TEXT",
+}
+
+var pgColumnValueTypes = map[string]ValueType{
+	"double precision": VALUE_TYPE_FLOAT64,
+	"bigint":           VALUE_TYPE_INT64,
+	"boolean":          VALUE_TYPE_BOOL,
+	"text":             VALUE_TYPE_STRING,
+}
+
+func (db *PostgresDB) DetectSchema() (Schema, error) {
+	rows, err := db.conn.Query(ctx, `
+		SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, DB_TABLE_NAME)
+	if err != nil {
+		return Schema{}, err
+	}
+	defer rows.Close()
+
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return Schema{}, err
+		}
+		columns[name] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return Schema{}, err
+	}
+
+	var normalized bool
+	err = db.conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = $1)`, DATA_POINTS_TABLE_NAME).Scan(&normalized)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	return schemaOfColumns(columns, pgColumnValueTypes, normalized)
+}
+
 func (db *PostgresDB) GetName() string {
 	return db.name
 }
 
 func (db *PostgresDB) Setup() error {
-	if _, err := db.conn.Exec(ctx, `DROP TABLE IF EXISTS `+DB_TABLE_NAME); err != nil {
+	partitioned := db.opts.PartitionWidth > 0
+	if partitioned && db.usingTimescale {
+		return fmt.Errorf("partitioning is only supported without timescale extension")
+	}
+	if db.schema.Normalized && (db.opts.ContinuousAggregates || len(db.opts.Indexes) > 0) {
+		return fmt.Errorf("continuous aggregates and indexes are not supported with the normalized schema")
+	}
+
+	for _, bucket := range []Bucket{BUCKET_HOUR, BUCKET_DAY} {
+		if _, err := db.conn.Exec(ctx, `DROP MATERIALIZED VIEW IF EXISTS `+continuousAggregateName(bucket)); err != nil {
+			return err
+		}
+	}
+
+	var tableType string
+	err := db.conn.QueryRow(ctx, `
+		SELECT table_type FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = $1`, DB_TABLE_NAME).Scan(&tableType)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		return err
 	}
 
-	if _, err := db.conn.Exec(ctx, fmt.Sprintf(`
+	for _, query := range []string{
+		dropDataObjects(tableType),
+		`DROP TABLE IF EXISTS ` + DATA_POINTS_TABLE_NAME,
+		`DROP TABLE IF EXISTS ` + SERIES_TABLE_NAME,
+	} {
+		if _, err := db.conn.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	db.series = seriesCache{}
+
+	var partitionClause string
+	if partitioned {
+		partitionClause = "PARTITION BY RANGE (start_time)"
+	}
+
+	if db.schema.Normalized {
+		if err := db.createNormalizedTables(partitionClause); err != nil {
+			return err
+		}
+	} else if _, err := db.conn.Exec(ctx, fmt.Sprintf(`
                 CREATE TABLE IF NOT EXISTS %v (
                     created_at  TIMESTAMPTZ         NOT NULL,
                     updated_at  TIMESTAMPTZ         NOT NULL,
@@ -45,24 +216,80 @@ func (db *PostgresDB) Setup() error {
                     interval    BIGINT     			NOT NULL,
                     area        TEXT         		NOT NULL,
                     source      TEXT         		NOT NULL,
-                    value       DOUBLE PRECISION    NOT NULL,
+                    %v,
 					PRIMARY KEY (start_time, interval, area)
-                )
-	`, DB_TABLE_NAME)); err != nil {
+                ) %v
+	`, DB_TABLE_NAME, db.schema.columnDefinitions(pgValueTypes[db.schema.ValueType], "JSONB"), partitionClause)); err != nil {
 		return err
 	}
 
+	if partitioned {
+		if err := db.createPartitions(); err != nil {
+			return err
+		}
+	}
+
+	for _, idx := range db.opts.Indexes {
+		statement, err := idx.statement()
+		if err != nil {
+			return err
+		}
+
+		if _, err := db.conn.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create index %v: %v", idx, err)
+		}
+	}
+
+	// jsonb_path_ops only supports @>, but is smaller and faster than the
+	// default operator class.
+	if db.schema.Labels {
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`CREATE INDEX %[1]v_labels ON %[1]v USING gin (labels jsonb_path_ops)`, db.schema.dataTable())); err != nil {
+			return fmt.Errorf("failed to create the labels index: %v", err)
+		}
+	}
+
 	if db.usingTimescale {
-		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`SELECT create_hypertable('%v', by_range('start_time', INTERVAL '60 days'));`, DB_TABLE_NAME)); err != nil {
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`SELECT create_hypertable('%v', by_range('start_time', INTERVAL '%v'));`,
+			db.schema.dataTable(), db.opts.ChunkInterval)); err != nil {
 			return err
 		}
 
-		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`
-		ALTER TABLE %v 
-		SET (
-				timescaledb.compress
-		);
-		`, DB_TABLE_NAME)); err != nil {
+		settings := []string{"timescaledb.compress"}
+		if db.opts.CompressSegmentBy != "" {
+			settings = append(settings, fmt.Sprintf("timescaledb.compress_segmentby = '%v'", db.opts.CompressSegmentBy))
+		}
+		if db.opts.CompressOrderBy != "" {
+			settings = append(settings, fmt.Sprintf("timescaledb.compress_orderby = '%v'", db.opts.CompressOrderBy))
+		}
+
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`ALTER TABLE %v SET (%v);`, db.schema.dataTable(), strings.Join(settings, ", "))); err != nil {
+			return err
+		}
+
+		if db.opts.ContinuousAggregates {
+			value, err := db.schema.ValueType.aggregateExpr("value", "%v::double precision")
+			if err != nil {
+				return fmt.Errorf("continuous aggregates: %v", err)
+			}
+
+			for _, bucket := range []Bucket{BUCKET_HOUR, BUCKET_DAY} {
+				if _, err := db.conn.Exec(ctx, fmt.Sprintf(`
+				CREATE MATERIALIZED VIEW %v WITH (timescaledb.continuous) AS
+				SELECT time_bucket(INTERVAL '1 %v', start_time) AS bucket, area,
+					count(*) AS count, min(%[4]v) AS min, max(%[4]v) AS max, avg(%[4]v) AS avg
+				FROM %[3]v
+				GROUP BY bucket, area
+				WITH NO DATA`, continuousAggregateName(bucket), bucket, DB_TABLE_NAME, value)); err != nil {
+					return err
+				}
+			}
+		}
+	} else if db.opts.ContinuousAggregates {
+		return fmt.Errorf("continuous aggregates are only supported with timescale extension")
+	}
+
+	if db.schema.Normalized {
+		if _, err := db.conn.Exec(ctx, `CREATE VIEW `+DB_TABLE_NAME+` AS `+db.schema.normalizedView("interval")); err != nil {
 			return err
 		}
 	}
@@ -70,18 +297,97 @@ func (db *PostgresDB) Setup() error {
 	return nil
 }
 
+// createNormalizedTables creates the series and the data_points tables of the
+// normalized schema. The primary key of data_points starts with series_id, so
+// the reads ordered by start_time get an index of their own. Timescale already
+// creates one for the hypertable.
+func (db *PostgresDB) createNormalizedTables(partitionClause string) error {
+	statements := []string{
+		fmt.Sprintf(`
+			CREATE TABLE %v (
+				id        BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+				area      TEXT   NOT NULL,
+				source    TEXT   NOT NULL,
+				interval  BIGINT NOT NULL,
+				UNIQUE (area, interval)
+			)`, SERIES_TABLE_NAME),
+		fmt.Sprintf(`
+			CREATE TABLE %v (
+				series_id   BIGINT       NOT NULL REFERENCES %v (id),
+				created_at  TIMESTAMPTZ  NOT NULL,
+				updated_at  TIMESTAMPTZ  NOT NULL,
+				start_time  TIMESTAMPTZ  NOT NULL,
+				%v,
+				PRIMARY KEY (series_id, start_time)
+			) %v`, DATA_POINTS_TABLE_NAME, SERIES_TABLE_NAME, db.schema.columnDefinitions(pgValueTypes[db.schema.ValueType], "JSONB"), partitionClause),
+	}
+	if !db.usingTimescale {
+		statements = append(statements, fmt.Sprintf(`CREATE INDEX %[1]v_start_time ON %[1]v (start_time)`, DATA_POINTS_TABLE_NAME))
+	}
+
+	for _, statement := range statements {
+		if _, err := db.conn.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upsertSeries writes the series of the doc and returns its id.
+func (db *PostgresDB) upsertSeries(doc DataObject) (int64, error) {
+	var id int64
+	err := db.conn.QueryRow(ctx, fmt.Sprintf(`
+		INSERT INTO %v (area, source, interval) VALUES ($1, $2, $3)
+		ON CONFLICT (area, interval) DO UPDATE SET source = EXCLUDED.source
+		RETURNING id`, SERIES_TABLE_NAME), doc.Area, doc.Source, doc.Interval).Scan(&id)
+	return id, err
+}
+
+func (db *PostgresDB) upsertQuery() string {
+	return `
+		INSERT INTO ` + db.schema.dataTable() + ` (` + db.schema.writeColumns("interval") + `)
+		VALUES (` + db.schema.placeholders(true) + `)
+		ON CONFLICT (` + db.schema.upsertConflictTarget() + `) DO UPDATE
+		SET ` + db.schema.upsertSetClause("%[1]v = EXCLUDED.%[1]v")
+}
+
+// createPartitions creates the partitions of PartitionWidth which cover the
+// range between PartitionFrom and PartitionTo, plus a default partition.
+func (db *PostgresDB) createPartitions() error {
+	if !db.opts.PartitionFrom.Before(db.opts.PartitionTo) {
+		return fmt.Errorf("invalid partition range: %v - %v", db.opts.PartitionFrom, db.opts.PartitionTo)
+	}
+
+	table := db.schema.dataTable()
+	for from := db.opts.PartitionFrom.UTC(); from.Before(db.opts.PartitionTo); from = from.Add(db.opts.PartitionWidth) {
+		to := from.Add(db.opts.PartitionWidth)
+
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE %v_p%v PARTITION OF %v FOR VALUES FROM ('%v') TO ('%v')`,
+			table, from.Format("20060102_150405"), table, from.Format(time.RFC3339), to.Format(time.RFC3339))); err != nil {
+			return fmt.Errorf("failed to create partition: %v", err)
+		}
+	}
+
+	if _, err := db.conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE %v_default PARTITION OF %v DEFAULT`, table, table)); err != nil {
+		return fmt.Errorf("failed to create default partition: %v", err)
+	}
+
+	return nil
+}
+
 func (db *PostgresDB) Close() error { return db.conn.Close(ctx) }
 
 func (db *PostgresDB) UpsertSingle(docs []DataObject) error {
-	query := `
-		INSERT INTO ` + DB_TABLE_NAME + ` (created_at, updated_at, start_time, interval, area, source, value)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (start_time, interval, area) DO UPDATE
-		SET updated_at = EXCLUDED.updated_at, source = EXCLUDED.source, value = EXCLUDED.value`
+	query := db.upsertQuery()
 
 	for _, doc := range docs {
-		if _, err := db.conn.Exec(ctx, query,
-			doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source, doc.Value); err != nil {
+		values, err := db.schema.writeValues([]DataObject{doc}, db.series, db.upsertSeries)
+		if err != nil {
+			return fmt.Errorf("UpsertSingle: %v", err)
+		}
+
+		if _, err := db.conn.Exec(ctx, query, values[0]...); err != nil {
 			return fmt.Errorf("UpsertSingle: %v", err)
 		}
 	}
@@ -90,17 +396,16 @@ func (db *PostgresDB) UpsertSingle(docs []DataObject) error {
 }
 
 func (db *PostgresDB) UpsertBulk(docs []DataObject) error {
-	query := `
-		INSERT INTO ` + DB_TABLE_NAME + ` (created_at, updated_at, start_time, interval, area, source, value)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (start_time, interval, area) DO UPDATE
-		SET updated_at = EXCLUDED.updated_at, source = EXCLUDED.source, value = EXCLUDED.value`
+	rows, err := db.schema.writeValues(docs, db.series, db.upsertSeries)
+	if err != nil {
+		return fmt.Errorf("UpsertBulk: %v", err)
+	}
 
+	query := db.upsertQuery()
 	batch := &pgx.Batch{}
 
-	for _, doc := range docs {
-		batch.Queue(query,
-			doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source, doc.Value)
+	for _, values := range rows {
+		batch.Queue(query, values...)
 	}
 
 	br := db.conn.SendBatch(context.Background(), batch)
@@ -124,31 +429,294 @@ func (db *PostgresDB) GetOrderedWithLimit(limit int) ([]DataObject, error) {
 	}
 	defer rows.Close()
 
+	results := make([]DataObject, 0, limit)
+	for rows.Next() {
+		obj, err := scanDataObject(rows, db.schema)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, obj)
+	}
+
+	return results, rows.Err()
+}
+
+// StreamAll reads the rows one at a time. The connection can not be used for
+// other queries until the iteration is finished.
+func (db *PostgresDB) StreamAll() iter.Seq2[DataObject, error] {
+	return func(yield func(DataObject, error) bool) {
+		query := fmt.Sprintf(`SELECT %v FROM %v`, db.schema.columns("interval"), DB_TABLE_NAME)
+
+		rows, err := db.conn.Query(ctx, query)
+		if err != nil {
+			yield(DataObject{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			obj, err := scanDataObject(rows, db.schema)
+			if !yield(obj, err) || err != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(DataObject{}, err)
+		}
+	}
+}
+
+// GetPageBefore uses a row comparison on the primary key, so postgres can
+// continue the backward index scan at the cursor.
+func (db *PostgresDB) GetPageBefore(cursor *Cursor, limit int) ([]DataObject, error) {
+	if cursor == nil {
+		return db.queryDataObjects(fmt.Sprintf(`
+			SELECT %v FROM %v
+			ORDER BY start_time DESC, interval DESC, area DESC LIMIT $1`, db.schema.columns("interval"), DB_TABLE_NAME), limit)
+	}
+
+	return db.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE (start_time, interval, area) < ($1, $2, $3)
+		ORDER BY start_time DESC, interval DESC, area DESC LIMIT $4`, db.schema.columns("interval"), DB_TABLE_NAME),
+		cursor.StartTime, cursor.Interval, cursor.Area, limit)
+}
+
+func (db *PostgresDB) GetPageOffset(offset, limit int) ([]DataObject, error) {
+	return db.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		ORDER BY start_time DESC, interval DESC, area DESC LIMIT $1 OFFSET $2`, db.schema.columns("interval"), DB_TABLE_NAME), limit, offset)
+}
+
+func (db *PostgresDB) queryDataObjects(query string, args ...any) ([]DataObject, error) {
+	rows, err := db.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
 	var results []DataObject
 	for rows.Next() {
-		var obj DataObject
-		if err := rows.Scan(&obj.CreatedAt, &obj.UpdatedAt, &obj.StartTime, &obj.Interval, &obj.Area, &obj.Source, &obj.Value); err != nil {
+		obj, err := scanDataObject(rows, db.schema)
+		if err != nil {
 			return nil, err
 		}
 
 		results = append(results, obj)
 	}
 
-	return results, nil
+	return results, rows.Err()
+}
+
+func (db *PostgresDB) GetOne(startTime time.Time, interval int64, area string) (DataObject, error) {
+	query := fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE start_time = $1 AND interval = $2 AND area = $3`, db.schema.columns("interval"), DB_TABLE_NAME)
+
+	obj, err := scanDataObject(db.conn.QueryRow(ctx, query, startTime, interval, area), db.schema)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return obj, ErrNotFound
+	}
+
+	return obj, err
+}
+
+// GetLatestPerArea uses DISTINCT ON on native postgres and timescale, which
+// selects whole rows. last() of every column could combine the columns of
+// different rows, if an area has several intervals at its latest start_time.
+func (db *PostgresDB) GetLatestPerArea() ([]DataObject, error) {
+	query := fmt.Sprintf(`
+		SELECT DISTINCT ON (area) %v
+		FROM %v ORDER BY area, start_time DESC, interval DESC`, db.schema.columns("interval"), DB_TABLE_NAME)
+
+	return db.queryDataObjects(query)
+}
+
+// GetAreaRange returns the rows of the area with a start_time in [from, to),
+// ordered by start_time. Unlike the reads of Database, it filters by the area
+// first, so it can use INDEX_AREA_START_TIME.
+func (db *PostgresDB) GetAreaRange(area string, from, to time.Time) ([]DataObject, error) {
+	return db.queryDataObjects(db.areaRangeQuery(), area, from, to)
+}
+
+func (db *PostgresDB) areaRangeQuery() string {
+	return fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE area = $1 AND start_time >= $2 AND start_time < $3
+		ORDER BY start_time`, db.schema.columns("interval"), DB_TABLE_NAME)
+}
+
+// GetLatestValue returns the start_time and the value of the newest row of
+// the area. Only these columns are read, so INDEX_COVERING_AREA_VALUE can
+// answer it with an index only scan.
+func (db *PostgresDB) GetLatestValue(area string) (time.Time, any, error) {
+	var (
+		obj   DataObject
+		dests = db.schema.scanDests()[:1]
+	)
+
+	err := db.conn.QueryRow(ctx, db.latestValueQuery(), area).Scan(&obj.StartTime, dests[0])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return obj.StartTime, nil, ErrNotFound
+	}
+	if err != nil {
+		return obj.StartTime, nil, err
+	}
+
+	db.schema.scanned(&obj, dests)
+	return obj.StartTime, obj.Value, nil
+}
+
+func (db *PostgresDB) latestValueQuery() string {
+	return fmt.Sprintf(`
+		SELECT start_time, value FROM %v
+		WHERE area = $1
+		ORDER BY start_time DESC LIMIT 1`, DB_TABLE_NAME)
+}
+
+// ExplainAreaReads returns the plans of GetAreaRange and GetLatestValue, to
+// show which index they use.
+func (db *PostgresDB) ExplainAreaReads(area string, from, to time.Time) (rangePlan, latestPlan string, err error) {
+	if rangePlan, err = db.explain(db.areaRangeQuery(), area, from, to); err != nil {
+		return "", "", err
+	}
+
+	latestPlan, err = db.explain(db.latestValueQuery(), area)
+	return rangePlan, latestPlan, err
+}
+
+// explain returns the plan of the query, executed with EXPLAIN ANALYZE.
+func (db *PostgresDB) explain(query string, args ...any) (string, error) {
+	rows, err := db.conn.Query(ctx, "EXPLAIN ANALYZE "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// GetByLabels filters with @>, which uses the GIN index on the labels.
+func (db *PostgresDB) GetByLabels(labels map[string]string, limit int) ([]DataObject, error) {
+	if !db.schema.Labels {
+		return nil, errNoLabels
+	}
+
+	return db.queryDataObjects(fmt.Sprintf(`
+		SELECT %v FROM %v
+		WHERE labels @> $1::jsonb
+		ORDER BY start_time DESC LIMIT $2`, db.schema.columns("interval"), DB_TABLE_NAME), encodeLabels(labels), limit)
+}
+
+// GetAggregated reads the continuous aggregates if they are enabled, otherwise
+// the raw rows are aggregated.
+func (db *PostgresDB) GetAggregated(bucket Bucket, from, to time.Time) ([]AggregateObject, error) {
+	if err := bucket.validate(); err != nil {
+		return nil, err
+	}
+
+	value, err := db.schema.ValueType.aggregateExpr("value", "%v::double precision")
+	if err != nil {
+		return nil, err
+	}
+
+	var query string
+	switch {
+	case db.usingTimescale && db.opts.ContinuousAggregates:
+		query = fmt.Sprintf(`
+			SELECT bucket, area, count, min, max, avg FROM %v
+			WHERE bucket >= $1 AND bucket < $2
+			ORDER BY bucket, area`, continuousAggregateName(bucket))
+	case db.usingTimescale:
+		query = fmt.Sprintf(`
+			SELECT time_bucket(INTERVAL '1 %v', start_time) AS bucket, area, count(*), min(%[3]v), max(%[3]v), avg(%[3]v)
+			FROM %[2]v WHERE start_time >= $1 AND start_time < $2
+			GROUP BY bucket, area ORDER BY bucket, area`, bucket, DB_TABLE_NAME, value)
+	default:
+		query = fmt.Sprintf(`
+			SELECT date_trunc('%v', start_time, 'UTC') AS bucket, area, count(*), min(%[3]v), max(%[3]v), avg(%[3]v)
+			FROM %[2]v WHERE start_time >= $1 AND start_time < $2
+			GROUP BY bucket, area ORDER BY bucket, area`, bucket, DB_TABLE_NAME, value)
+	}
+
+	rows, err := db.conn.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []AggregateObject
+	for rows.Next() {
+		var obj AggregateObject
+		if err := rows.Scan(&obj.Bucket, &obj.Area, &obj.Count, &obj.Min, &obj.Max, &obj.Avg); err != nil {
+			return nil, err
+		}
+
+		results = append(results, obj)
+	}
+
+	return results, rows.Err()
+}
+
+// RefreshContinuousAggregates materializes the hourly and daily continuous
+// aggregates for the given time range.
+func (db *PostgresDB) RefreshContinuousAggregates(from, to time.Time) error {
+	if !db.usingTimescale || !db.opts.ContinuousAggregates {
+		return fmt.Errorf("continuous aggregates are not enabled")
+	}
+
+	// The window is inlined, because the arguments of the procedure are "any" typed.
+	for _, bucket := range []Bucket{BUCKET_HOUR, BUCKET_DAY} {
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf(`CALL refresh_continuous_aggregate('%v', '%v'::timestamptz, '%v'::timestamptz)`,
+			continuousAggregateName(bucket), from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))); err != nil {
+			return fmt.Errorf("RefreshContinuousAggregates: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func continuousAggregateName(bucket Bucket) string {
+	if bucket == BUCKET_DAY {
+		return DB_TABLE_NAME + "_daily"
+	}
+
+	return DB_TABLE_NAME + "_hourly"
+}
+
+func (db *PostgresDB) Count() (int64, error) {
+	var count int64
+	err := db.conn.QueryRow(ctx, fmt.Sprintf(`SELECT count(*) FROM %v`, DB_TABLE_NAME)).Scan(&count)
+	return count, err
 }
 
 func (db *PostgresDB) TableSizeInKB() (int, error) {
 
 	var totalSize string
 
+	// The size of a partitioned table is the sum of its partitions. The series
+	// of the normalized schema are added to the size of the data points.
 	var query string
 	if db.usingTimescale {
 		query = `SELECT hypertable_size($1) AS total_size;`
+	} else if db.opts.PartitionWidth > 0 {
+		query = `SELECT COALESCE(sum(pg_total_relation_size(relid)), 0) AS total_size FROM pg_partition_tree($1);`
 	} else {
 		query = `SELECT pg_total_relation_size($1) AS total_size;`
 	}
 
-	err := db.conn.QueryRow(context.Background(), query, DB_TABLE_NAME).Scan(&totalSize)
+	err := db.conn.QueryRow(context.Background(), query, db.schema.dataTable()).Scan(&totalSize)
 	if err != nil {
 		return 0, err
 	}
@@ -158,6 +726,15 @@ func (db *PostgresDB) TableSizeInKB() (int, error) {
 		return 0, err
 	}
 
+	if db.schema.Normalized {
+		var seriesSize int64
+		err := db.conn.QueryRow(ctx, `SELECT pg_total_relation_size($1)`, SERIES_TABLE_NAME).Scan(&seriesSize)
+		if err != nil {
+			return 0, err
+		}
+		sizeInBytes += int(seriesSize)
+	}
+
 	return sizeInBytes / 1024, nil
 }
 
@@ -169,9 +746,110 @@ func (db *PostgresDB) ExecManualCompression() error {
 		return fmt.Errorf("compression is only supported with timescale extension")
 	}
 
-	if _, err := db.conn.Exec(ctx, fmt.Sprintf(`SELECT compress_chunk(c) from show_chunks('%v') c;`, DB_TABLE_NAME)); err != nil {
+	// Already compressed chunks are skipped, while partially compressed chunks
+	// (compressed chunks which received writes afterwards) get recompressed.
+	if _, err := db.conn.Exec(ctx, fmt.Sprintf(`SELECT compress_chunk(c, if_not_compressed => true) from show_chunks('%v') c;`, db.schema.dataTable())); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+type TimescaleChunk struct {
+	Name                  string
+	RangeStart            time.Time
+	RangeEnd              time.Time
+	IsCompressed          bool
+	IsPartiallyCompressed bool // compressed chunk which received writes after the compression
+	TotalBytes            int64
+}
+
+// ChunkStatuses returns the chunks of the hypertable ordered by their range.
+func (db *PostgresDB) ChunkStatuses() ([]TimescaleChunk, error) {
+	if !db.usingTimescale {
+		return nil, fmt.Errorf("chunks are only supported with timescale extension")
+	}
+
+	// The partial flag is only exposed through the catalog (status bit 8).
+	query := `
+		SELECT c.chunk_name, c.range_start, c.range_end, c.is_compressed,
+			(ch.status & 8) != 0 AS is_partial, s.total_bytes
+		FROM timescaledb_information.chunks c
+		JOIN _timescaledb_catalog.chunk ch ON ch.schema_name = c.chunk_schema AND ch.table_name = c.chunk_name
+		JOIN chunks_detailed_size($1::text::regclass) s ON s.chunk_schema = c.chunk_schema AND s.chunk_name = c.chunk_name
+		WHERE c.hypertable_name = $1::text
+		ORDER BY c.range_start`
+
+	rows, err := db.conn.Query(ctx, query, db.schema.dataTable())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []TimescaleChunk
+	for rows.Next() {
+		var c TimescaleChunk
+		if err := rows.Scan(&c.Name, &c.RangeStart, &c.RangeEnd, &c.IsCompressed, &c.IsPartiallyCompressed, &c.TotalBytes); err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, c)
+	}
+
+	return chunks, rows.Err()
+}
+
+type ChunkCompressionStats struct {
+	Name                   string
+	CompressionStatus      string // "Compressed" or "Uncompressed"
+	BeforeCompressionBytes int64  // 0 for uncompressed chunks
+	AfterCompressionBytes  int64  // 0 for uncompressed chunks
+}
+
+type CompressionStats struct {
+	TotalChunks            int
+	CompressedChunks       int
+	BeforeCompressionBytes int64
+	AfterCompressionBytes  int64
+	Chunks                 []ChunkCompressionStats
+}
+
+// CompressionStats returns the sizes of the chunks before and after the
+// compression, as reported by chunk_compression_stats and
+// hypertable_compression_stats. The sizes include the indexes and toast.
+func (db *PostgresDB) CompressionStats() (CompressionStats, error) {
+	var stats CompressionStats
+
+	if !db.usingTimescale {
+		return stats, fmt.Errorf("compression is only supported with timescale extension")
+	}
+
+	if err := db.conn.QueryRow(ctx, `
+		SELECT COALESCE(total_chunks, 0), COALESCE(number_compressed_chunks, 0),
+			COALESCE(before_compression_total_bytes, 0), COALESCE(after_compression_total_bytes, 0)
+		FROM hypertable_compression_stats($1::text::regclass)`, db.schema.dataTable()).Scan(
+		&stats.TotalChunks, &stats.CompressedChunks, &stats.BeforeCompressionBytes, &stats.AfterCompressionBytes); err != nil {
+		return stats, err
+	}
+
+	rows, err := db.conn.Query(ctx, `
+		SELECT chunk_name, compression_status,
+			COALESCE(before_compression_total_bytes, 0), COALESCE(after_compression_total_bytes, 0)
+		FROM chunk_compression_stats($1::text::regclass)
+		ORDER BY chunk_name`, db.schema.dataTable())
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c ChunkCompressionStats
+		if err := rows.Scan(&c.Name, &c.CompressionStatus, &c.BeforeCompressionBytes, &c.AfterCompressionBytes); err != nil {
+			return stats, err
+		}
+
+		stats.Chunks = append(stats.Chunks, c)
+	}
+
+	return stats, rows.Err()
+}
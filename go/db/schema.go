@@ -0,0 +1,400 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Schema is the layout of the data_objects table. Every database creates its
+// table with the schema of the last SetSchema call in Setup, so the schema has
+// to be set before Setup.
+type Schema struct {
+	// ValueType is the type of the value columns. DataObject.Value and
+	// DataObject.Values hold the go type of the value type (float64, int64,
+	// bool or string), the upserts reject values of other types.
+	ValueType ValueType
+	// Fields is the number of value columns of a row. 1 is the narrow schema
+	// with the single value column. A wide schema stores DataObject.Values in
+	// the additional columns value_1 to value_<Fields-1> (fields in mongodb).
+	// The aggregates only use the value column.
+	Fields int
+	// Labels stores DataObject.Labels in a labels column after the value
+	// columns: JSONB in postgres, JSON in mysql and duckdb and a subdocument in
+	// mongodb. Required by GetByLabels.
+	Labels bool
+	// Normalized stores area, source and interval once per series in the
+	// series table and the rows in data_points, keyed by series_id and
+	// start_time. data_objects becomes a view which joins them. The source is
+	// a column of the series, so an upsert with a different source changes it
+	// for every row of the series. Only supported by the SQL databases.
+	Normalized bool
+}
+
+var DefaultSchema = Schema{
+	ValueType: VALUE_TYPE_FLOAT64,
+	Fields:    1,
+}
+
+// withDefaults fills the empty fields of the schema with the defaults and
+// validates it.
+func (s Schema) withDefaults() (Schema, error) {
+	if s.ValueType == "" {
+		s.ValueType = DefaultSchema.ValueType
+	}
+	if s.Fields == 0 {
+		s.Fields = DefaultSchema.Fields
+	}
+	if s.Fields < 1 {
+		return s, fmt.Errorf("invalid number of fields: %v", s.Fields)
+	}
+
+	return s, s.ValueType.validate()
+}
+
+// valueColumnList holds the names of the value columns of a number of fields
+// and the position of every name.
+type valueColumnList struct {
+	names     []string
+	positions map[string]int
+}
+
+// valueColumnLists caches the valueColumnList of every number of fields, as
+// the columns are needed for every written row.
+var valueColumnLists sync.Map
+
+func (s Schema) valueColumnList() valueColumnList {
+	if list, ok := valueColumnLists.Load(s.Fields); ok {
+		return list.(valueColumnList)
+	}
+
+	list := valueColumnList{names: make([]string, s.Fields), positions: make(map[string]int, s.Fields)}
+	for i := range s.Fields {
+		list.names[i] = "value"
+		if i > 0 {
+			list.names[i] = fmt.Sprintf("value_%d", i)
+		}
+		list.positions[list.names[i]] = i
+	}
+
+	valueColumnLists.Store(s.Fields, list)
+	return list
+}
+
+// valueColumns returns the names of the value columns: value, value_1, ...
+// The slice is shared, appending to it copies it.
+func (s Schema) valueColumns() []string {
+	return s.valueColumnList().names
+}
+
+// columns returns the comma separated columns of the table in the order of
+// the DataObject fields. mysql names the interval column resolution.
+func (s Schema) columns(interval string) string {
+	return strings.Join(s.columnNames(interval), ", ")
+}
+
+func (s Schema) columnNames(interval string) []string {
+	return append([]string{"created_at", "updated_at", "start_time", interval, "area", "source"}, s.dataColumns()...)
+}
+
+// dataColumns returns the columns which follow the key and the source: the
+// value columns and the labels.
+func (s Schema) dataColumns() []string {
+	columns := s.valueColumns()
+	if s.Labels {
+		columns = append(columns, "labels")
+	}
+
+	return columns
+}
+
+// columnDefinitions returns the definitions of the data columns for a CREATE
+// TABLE statement, with the given types of the value and the labels columns.
+func (s Schema) columnDefinitions(valueType, labelsType string) string {
+	definitions := formatFields(s.valueColumns(), "%v "+valueType+" NOT NULL")
+	if s.Labels {
+		definitions += ", labels " + labelsType + " NOT NULL"
+	}
+
+	return definitions
+}
+
+// checkValues fails if a value of the doc doesn't have the value type or the
+// doc has more values than the value columns.
+func (s Schema) checkValues(doc DataObject) error {
+	if len(doc.Values) > s.Fields-1 {
+		return fmt.Errorf("%v values for %v value columns", len(doc.Values)+1, s.Fields)
+	}
+
+	if err := s.ValueType.check(doc.Value); err != nil {
+		return fmt.Errorf("value: %v", err)
+	}
+
+	for i, value := range doc.Values {
+		if err := s.ValueType.check(value); err != nil {
+			return fmt.Errorf("value_%d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// checkAllValues is checkValues for every doc.
+func (s Schema) checkAllValues(docs []DataObject) error {
+	for _, doc := range docs {
+		if err := s.checkValues(doc); err != nil {
+			return fmt.Errorf("%v %v: %v", doc.Area, doc.StartTime, err)
+		}
+	}
+
+	return nil
+}
+
+// values returns the values of the doc in the order of columns, with the
+// labels encoded as JSON. The values have to be checked with checkValues.
+func (s Schema) values(doc DataObject) []any {
+	values := make([]any, 0, 7+s.Fields)
+	values = append(values, doc.CreatedAt, doc.UpdatedAt, doc.StartTime, doc.Interval, doc.Area, doc.Source)
+
+	return s.appendDataValues(values, doc)
+}
+
+// appendDataValues appends the values of the dataColumns of the doc.
+func (s Schema) appendDataValues(values []any, doc DataObject) []any {
+	for i := range s.Fields {
+		values = append(values, s.value(doc, i))
+	}
+	if s.Labels {
+		values = append(values, encodeLabels(doc.Labels))
+	}
+
+	return values
+}
+
+// encodeLabels returns the labels as a JSON object, nil labels are empty.
+func encodeLabels(labels map[string]string) string {
+	if labels == nil {
+		return "{}"
+	}
+
+	// A map of strings can always be marshaled.
+	data, _ := json.Marshal(labels)
+	return string(data)
+}
+
+// labelsScanner scans a labels column, which is JSON text in postgres and
+// mysql and an already decoded object in duckdb.
+type labelsScanner map[string]string
+
+func (l *labelsScanner) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*map[string]string)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*map[string]string)(l))
+	case map[string]any:
+		*l = make(labelsScanner, len(v))
+		for key, value := range v {
+			(*l)[key] = fmt.Sprint(value)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported labels: %v (%T)", src, src)
+	}
+}
+
+// fieldValue returns the value of the field with the given column name.
+func (s Schema) fieldValue(doc DataObject, field string) any {
+	if i, ok := s.valueColumnList().positions[field]; ok {
+		return s.value(doc, i)
+	}
+
+	return doc.fieldValue(field)
+}
+
+// value returns the value of the value column at the position i. Missing
+// values of a wide schema are the zero value of the value type.
+func (s Schema) value(doc DataObject, i int) any {
+	switch {
+	case i == 0:
+		return doc.Value
+	case i <= len(doc.Values):
+		return doc.Values[i-1]
+	default:
+		return s.ValueType.zero()
+	}
+}
+
+// placeholders returns a placeholder for every column of writeColumns, $1,
+// $2, ... if numbered and ?, ?, ... otherwise.
+func (s Schema) placeholders(numbered bool) string {
+	p := make([]string, len(s.writeColumnNames("interval")))
+	for i := range p {
+		p[i] = "?"
+		if numbered {
+			p[i] = fmt.Sprintf("$%d", i+1)
+		}
+	}
+
+	return strings.Join(p, ", ")
+}
+
+// scanDests returns the destinations of the value columns for Scan, pointers
+// to the go type of the value type.
+func (s Schema) scanDests() []any {
+	dests := make([]any, s.Fields)
+	for i := range dests {
+		switch s.ValueType {
+		case VALUE_TYPE_INT64:
+			dests[i] = new(int64)
+		case VALUE_TYPE_BOOL:
+			dests[i] = new(bool)
+		case VALUE_TYPE_STRING:
+			dests[i] = new(string)
+		default:
+			dests[i] = new(float64)
+		}
+	}
+
+	return dests
+}
+
+// scanned sets the values which were scanned into the dests of scanDests.
+func (s Schema) scanned(obj *DataObject, dests []any) {
+	values := make([]any, len(dests))
+	for i, dest := range dests {
+		switch v := dest.(type) {
+		case *int64:
+			values[i] = *v
+		case *bool:
+			values[i] = *v
+		case *string:
+			values[i] = *v
+		case *float64:
+			values[i] = *v
+		}
+	}
+
+	obj.Value = values[0]
+	if len(values) > 1 {
+		obj.Values = values[1:]
+	}
+}
+
+// ValueType is the type of the value columns. Every SQL database maps the
+// value types to the types of its columns, and the data types reported by
+// information_schema.columns back to them for DetectSchema.
+type ValueType string
+
+const (
+	VALUE_TYPE_FLOAT64 ValueType = "float64"
+	// Integer counters.
+	VALUE_TYPE_INT64 ValueType = "int64"
+	// Status flags.
+	VALUE_TYPE_BOOL ValueType = "bool"
+	// Short status strings, at most 50 characters in mysql.
+	VALUE_TYPE_STRING ValueType = "string"
+)
+
+var VALUE_TYPES = []ValueType{VALUE_TYPE_FLOAT64, VALUE_TYPE_INT64, VALUE_TYPE_BOOL, VALUE_TYPE_STRING}
+
+var errNotAggregatable = errors.New("the values can not be aggregated")
+
+var errNoLabels = errors.New("the schema has no labels")
+
+var errNormalizedUnsupported = errors.New("the normalized schema is only supported by the SQL databases")
+
+func (t ValueType) validate() error {
+	switch t {
+	case VALUE_TYPE_FLOAT64, VALUE_TYPE_INT64, VALUE_TYPE_BOOL, VALUE_TYPE_STRING:
+		return nil
+	default:
+		return fmt.Errorf("unknown value type: %v", t)
+	}
+}
+
+// check fails if the value doesn't have the go type of the value type.
+func (t ValueType) check(value any) error {
+	var ok bool
+	switch t {
+	case VALUE_TYPE_INT64:
+		_, ok = value.(int64)
+	case VALUE_TYPE_BOOL:
+		_, ok = value.(bool)
+	case VALUE_TYPE_STRING:
+		_, ok = value.(string)
+	default:
+		_, ok = value.(float64)
+	}
+
+	if !ok {
+		return fmt.Errorf("%v (%T) is not a %v", value, value, t)
+	}
+
+	return nil
+}
+
+// zero returns the zero value of the go type of the value type.
+func (t ValueType) zero() any {
+	switch t {
+	case VALUE_TYPE_INT64:
+		return int64(0)
+	case VALUE_TYPE_BOOL:
+		return false
+	case VALUE_TYPE_STRING:
+		return ""
+	default:
+		return 0.0
+	}
+}
+
+// parse parses the text of a value, e.g. a CSV column, into the go type of
+// the value type.
+func (t ValueType) parse(s string) (any, error) {
+	switch t {
+	case VALUE_TYPE_INT64:
+		return strconv.ParseInt(s, 10, 64)
+	case VALUE_TYPE_BOOL:
+		return strconv.ParseBool(s)
+	case VALUE_TYPE_STRING:
+		return s, nil
+	default:
+		return strconv.ParseFloat(s, 64)
+	}
+}
+
+// decode converts a value which was decoded without a type, e.g. from bson,
+// into the go type of the value type. Only lossless conversions are made.
+func (t ValueType) decode(value any) (any, error) {
+	switch v := value.(type) {
+	case int32:
+		if t == VALUE_TYPE_INT64 {
+			return int64(v), nil
+		}
+	case float32:
+		if t == VALUE_TYPE_FLOAT64 {
+			return float64(v), nil
+		}
+	}
+
+	return value, t.check(value)
+}
+
+// aggregateExpr returns the expression of the value column in min, max and avg.
+// Integers and booleans are cast with castFormat, e.g. "%v::double precision".
+func (t ValueType) aggregateExpr(column, castFormat string) (string, error) {
+	switch t {
+	case VALUE_TYPE_INT64, VALUE_TYPE_BOOL:
+		return fmt.Sprintf(castFormat, column), nil
+	case VALUE_TYPE_STRING:
+		return "", errNotAggregatable
+	default:
+		return column, nil
+	}
+}
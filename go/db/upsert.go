@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The upsert semantics shared by all of the backends. A row is identified by
+// the key fields. If a row with the same key already exists, only the updatable
+// fields are overwritten, while the insert-only fields keep the values of the
+// first insert.
+var (
+	UpsertKeyFields  = []string{"start_time", "interval", "area"}
+	InsertOnlyFields = []string{"created_at"}
+	UpdatableFields  = []string{"updated_at", "source", "value"}
+)
+
+// updatableFields returns the updatable fields, with the additional value
+// columns of a wide schema and the labels. The source is not a column of the
+// data points of the normalized schema.
+func (s Schema) updatableFields() []string {
+	fields := append(slices.Clone(UpdatableFields), s.dataColumns()[1:]...)
+	if s.Normalized {
+		fields = slices.DeleteFunc(fields, func(field string) bool { return field == "source" })
+	}
+
+	return fields
+}
+
+// upsertSetClause formats every updatable field with the format, e.g.
+// "%[1]v = EXCLUDED.%[1]v", and joins them into the SET clause of an upsert.
+func (s Schema) upsertSetClause(format string) string {
+	return formatFields(s.updatableFields(), format)
+}
+
+// formatFields formats every field with the format and joins them with commas.
+func formatFields(fields []string, format string) string {
+	formatted := make([]string, len(fields))
+	for i, field := range fields {
+		formatted[i] = fmt.Sprintf(format, field)
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+// upsertConflictTarget returns the comma separated key fields, series_id and
+// start_time in the normalized schema.
+func (s Schema) upsertConflictTarget() string {
+	if s.Normalized {
+		return "series_id, start_time"
+	}
+
+	return strings.Join(UpsertKeyFields, ", ")
+}
+
+// fieldValue returns the value of the field with the given column name.
+func (o DataObject) fieldValue(field string) any {
+	switch field {
+	case "created_at":
+		return o.CreatedAt
+	case "updated_at":
+		return o.UpdatedAt
+	case "start_time":
+		return o.StartTime
+	case "interval":
+		return o.Interval
+	case "area":
+		return o.Area
+	case "source":
+		return o.Source
+	case "value":
+		return o.Value
+	case "labels":
+		return o.Labels
+	default:
+		panic(fmt.Sprintf("unknown field: %v", field))
+	}
+}
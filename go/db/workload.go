@@ -0,0 +1,68 @@
+package db
+
+import (
+	"math/rand"
+	"time"
+)
+
+// CorrectionStream simulates a live feed of hourly values in which already
+// published values get revised later on, as it happens with energy market data.
+// Every batch consists of new hours at the head of the stream and a share of
+// corrections to rows which were published in the past. The corrections use
+// the same (start_time, interval, area) key as the original rows, so they
+// are upserts into old (and for timescale, possibly compressed) chunks.
+type CorrectionStream struct {
+	next            time.Time     // start_time of the next new hour
+	earliest        time.Time     // corrections never reach before this time
+	window          time.Duration // how far back from the head corrections can reach
+	correctionRatio float64       // share of every batch which are corrections
+	rnd             *rand.Rand
+}
+
+// NewCorrectionStream returns a stream which continues after the rows created
+// by GenerateFakeData(numExisting). Corrections are spread uniformly over the
+// window before the head of the stream, but never before BaseTime.
+func NewCorrectionStream(numExisting int, window time.Duration, correctionRatio float64, seed int64) *CorrectionStream {
+	return &CorrectionStream{
+		next:            BaseTime.Add(time.Duration(numExisting) * time.Hour),
+		earliest:        BaseTime,
+		window:          window,
+		correctionRatio: min(max(correctionRatio, 0), 1),
+		rnd:             rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NextBatch returns size rows, of which roughly size*correctionRatio are
+// corrections of historical rows and the rest are new hours.
+func (s *CorrectionStream) NextBatch(size int) []DataObject {
+	rows := make([]DataObject, 0, size)
+
+	for range size {
+		if s.rnd.Float64() < s.correctionRatio && s.next.After(s.earliest) {
+			rows = append(rows, newFakeObject(s.correctionTime(), s.rnd.Float64()))
+			continue
+		}
+
+		rows = append(rows, newFakeObject(s.next, s.rnd.Float64()))
+		s.next = s.next.Add(time.Hour)
+	}
+
+	return rows
+}
+
+// Head returns the start_time of the next new hour.
+func (s *CorrectionStream) Head() time.Time { return s.next }
+
+func (s *CorrectionStream) correctionTime() time.Time {
+	from := s.next.Add(-s.window)
+	if from.Before(s.earliest) {
+		from = s.earliest
+	}
+
+	hours := int64(s.next.Sub(from) / time.Hour)
+	if hours <= 0 {
+		return from
+	}
+
+	return from.Add(time.Duration(s.rnd.Int63n(hours)) * time.Hour)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"timeseries-benchmark/db"
+)
+
+// export streams the data_objects of a database to a CSV or JSON Lines file.
+// The flags of the layout have to match the table of the database.
+//
+//	go run . export -db pg-ntv -out data.csv
+//	go run . export -db mongodb -mongo-timeseries -format jsonl > data.jsonl
+//	go run . export -db mysql -fields 10 -labels -normalized -out wide.jsonl
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	name := flags.String("db", "", fmt.Sprintf("database to export (%v)", strings.Join(DATABASE_NAMES, ", ")))
+	out := flags.String("out", "-", "output file, - for stdout")
+	format := flags.String("format", "", "csv or jsonl (default: the extension of -out, csv for stdout)")
+	schema := addSchemaFlags(flags)
+	normalized := flags.Bool("normalized", false, "the table has the normalized schema (SQL databases)")
+	mongoTimeSeries := flags.Bool("mongo-timeseries", false, "the rows are in a time-series collection (mongodb)")
+	flags.Parse(args)
+
+	if *name == "" {
+		flags.Usage()
+		return fmt.Errorf("export: -db is required")
+	}
+
+	exportFormat := db.ExportFormat(*format)
+	if exportFormat == "" {
+		exportFormat = db.EXPORT_FORMAT_CSV
+		if ext := filepath.Ext(*out); ext == ".jsonl" || ext == ".ndjson" {
+			exportFormat = db.EXPORT_FORMAT_JSONL
+		}
+	}
+
+	layout := databaseLayout{Schema: schema.schema(*normalized), MongoTimeSeries: *mongoTimeSeries}
+
+	// The CSV has the columns of the narrow schema, it would drop the rest.
+	if exportFormat == db.EXPORT_FORMAT_CSV && (layout.Schema.Fields > 1 || layout.Schema.Labels) {
+		return fmt.Errorf("export: csv only holds a single value column without labels, use -format jsonl")
+	}
+
+	dbInstance, err := openDatabase(*name, layout)
+	if err != nil {
+		return err
+	}
+	defer dbInstance.Close()
+
+	if err := checkSchema(dbInstance, layout); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out != "-" {
+		f, err = os.Create(*out)
+		if err != nil {
+			return err
+		}
+		// Closes the file on the errors, the error of a second Close is ignored.
+		defer f.Close()
+		w = f
+	}
+
+	now := time.Now()
+	count, err := db.Export(w, dbInstance, exportFormat)
+	if err != nil {
+		return err
+	}
+
+	// The error of Close is the last chance to notice a failed write.
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("export: failed to close %v: %v", *out, err)
+		}
+	}
+
+	log.Printf("Exported %v rows from %v as %v in %v", count, dbInstance.GetName(), exportFormat, time.Since(now).Round(time.Millisecond))
+	return nil
+}
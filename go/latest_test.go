@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"timeseries-benchmark/db"
+)
+
+// GetLatestPerArea has to return whole rows. At the latest start_time every
+// area has rows with three intervals, which differ in every other column, and
+// the row with the largest interval wins.
+func TestLatestPerArea(t *testing.T) {
+	latestTime := db.BaseTime.Add(2 * time.Hour)
+
+	var (
+		rows []db.DataObject
+		want = map[string]db.DataObject{}
+	)
+	for i, area := range []string{"area-a", "area-b"} {
+		for hour := range 3 {
+			row := db.GenerateFakeData(1)[0]
+			row.Area = area
+			row.StartTime = db.BaseTime.Add(time.Duration(hour) * time.Hour)
+			rows = append(rows, row)
+		}
+
+		smaller := db.GenerateFakeData(1)[0]
+		smaller.Area, smaller.StartTime, smaller.Interval = area, latestTime, 900000
+		smaller.Source = "quarter-hourly"
+		smaller.Value = 1.0
+		smaller.CreatedAt = smaller.CreatedAt.Add(-time.Hour)
+
+		larger := db.GenerateFakeData(1)[0]
+		larger.Area, larger.StartTime, larger.Interval = area, latestTime, 86400000
+		larger.Source = "daily"
+		larger.Value = 2.0 + float64(i)
+		larger.CreatedAt = larger.CreatedAt.Add(-2 * time.Hour)
+
+		rows = append(rows, smaller, larger)
+		want[area] = larger
+	}
+
+	duckDb, parquet := localDatabases(t)
+
+	for _, dbInstance := range []db.Database{duckDb, parquet} {
+		if err := dbInstance.Setup(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := dbInstance.UpsertBulk(rows); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		latest, err := dbInstance.GetLatestPerArea()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if len(latest) != len(want) {
+			t.Fatalf("%v: expected %v rows, got %v", dbInstance.GetName(), len(want), len(latest))
+		}
+
+		for _, got := range latest {
+			expected := want[got.Area]
+			if !got.StartTime.Equal(expected.StartTime) || got.Interval != expected.Interval || got.Source != expected.Source ||
+				got.Value != expected.Value || !closeTo(got.CreatedAt, expected.CreatedAt) {
+				t.Errorf("%v: expected the row %+v, got %+v", dbInstance.GetName(), expected, got)
+			}
+		}
+	}
+}
@@ -1,18 +1,37 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
 	"time"
 	"timeseries-benchmark/db"
 )
 
 func main() {
-	if err := run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
 }
 
-func run() error {
+// run executes the command in args. Without a command the read speed of the
+// databases is logged.
+func run(args []string) error {
+	if len(args) == 0 {
+		return readSpeed()
+	}
+
+	switch args[0] {
+	case "export":
+		return export(args[1:])
+	case "migrate":
+		return migrate(args[1:])
+	default:
+		return fmt.Errorf("unknown command: %v (export, migrate)", args[0])
+	}
+}
+
+func readSpeed() error {
 	mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
 	if err != nil {
 		return err
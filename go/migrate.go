@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"timeseries-benchmark/db"
+)
+
+// migrationFile is the resume state of a migration, saved after every batch.
+type migrationFile struct {
+	From       string            `json:"from"`
+	To         string            `json:"to"`
+	FromLayout databaseLayout    `json:"from_layout"`
+	ToLayout   databaseLayout    `json:"to_layout"`
+	State      db.MigrationState `json:"state"`
+}
+
+// migrate copies the data_objects of one database into another and verifies
+// the row counts at the end. An interrupted migration continues from its state
+// file when the same command is run again. The value columns and the labels of
+// both databases are described by the same flags, so a copy can't drop any of
+// them. Only the layout of the tables can differ.
+//
+//	go run . migrate -from pg-ntv -to mongodb
+//	go run . migrate -from mysql -from-normalized -fields 10 -to mongodb -to-mongo-timeseries
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fromName := flags.String("from", "", fmt.Sprintf("source database (%v)", strings.Join(DATABASE_NAMES, ", ")))
+	toName := flags.String("to", "", "destination database")
+	batchSize := flags.Int("batch", 10_000, "rows per batch")
+	statePath := flags.String("state", "", "resume state file (default: migrate-<from>-<to>.json)")
+	setup := flags.Bool("setup", true, "set up (drop and create) the destination table before a new migration")
+	schema := addSchemaFlags(flags)
+	fromNormalized := flags.Bool("from-normalized", false, "the source table has the normalized schema (SQL databases)")
+	toNormalized := flags.Bool("to-normalized", false, "the destination table has the normalized schema (SQL databases)")
+	fromMongoTimeSeries := flags.Bool("from-mongo-timeseries", false, "the source rows are in a time-series collection (mongodb)")
+	toMongoTimeSeries := flags.Bool("to-mongo-timeseries", false, "the destination rows are in a time-series collection (mongodb)")
+	flags.Parse(args)
+
+	if *fromName == "" || *toName == "" {
+		flags.Usage()
+		return errors.New("migrate: -from and -to are required")
+	}
+
+	// Both of the parquet databases use the same directory.
+	if *fromName == *toName || (strings.HasPrefix(*fromName, "parquet-") && strings.HasPrefix(*toName, "parquet-")) {
+		return fmt.Errorf("migrate: %v and %v are the same database", *fromName, *toName)
+	}
+
+	if *statePath == "" {
+		*statePath = fmt.Sprintf("migrate-%v-%v.json", *fromName, *toName)
+	}
+
+	file, resume, err := readMigrationFile(*statePath)
+	if err != nil {
+		return err
+	}
+	if resume && (file.From != *fromName || file.To != *toName) {
+		return fmt.Errorf("migrate: %v belongs to the migration from %v to %v", *statePath, file.From, file.To)
+	}
+
+	fromLayout := databaseLayout{Schema: schema.schema(*fromNormalized), MongoTimeSeries: *fromMongoTimeSeries}
+	toLayout := databaseLayout{Schema: schema.schema(*toNormalized), MongoTimeSeries: *toMongoTimeSeries}
+	if resume && (file.FromLayout != fromLayout || file.ToLayout != toLayout) {
+		return fmt.Errorf("migrate: %v was started with the layouts %+v and %+v, not %+v and %+v",
+			*statePath, file.FromLayout, file.ToLayout, fromLayout, toLayout)
+	}
+	file.From, file.To = *fromName, *toName
+	file.FromLayout, file.ToLayout = fromLayout, toLayout
+
+	from, err := openDatabase(*fromName, fromLayout)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	if err := checkSchema(from, fromLayout); err != nil {
+		return err
+	}
+
+	to, err := openDatabase(*toName, toLayout)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	// The existing table of the destination has to match as well, unless it
+	// is replaced by Setup.
+	if resume || !*setup {
+		if err := checkSchema(to, toLayout); err != nil {
+			return err
+		}
+	}
+
+	useFastestWrites(to)
+
+	if resume {
+		log.Printf("Resuming the migration from %v to %v after %v rows", *fromName, *toName, file.State.Copied)
+	} else if *setup {
+		if err := to.Setup(); err != nil {
+			return err
+		}
+	}
+
+	if !file.State.Done {
+		start := time.Now()
+		copiedAtStart := file.State.Copied
+		lastLog := start
+
+		file.State, err = db.Migrate(from, to, *batchSize, file.State, func(state db.MigrationState) error {
+			file.State = state
+			if time.Since(lastLog) > 5*time.Second || state.Done {
+				lastLog = time.Now()
+				rate := float64(state.Copied-copiedAtStart) / time.Since(start).Seconds()
+				log.Printf("Copied %v rows (%.0f rows/s)", state.Copied, rate)
+			}
+
+			return writeMigrationFile(*statePath, file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return verifyMigration(from, to, *statePath)
+}
+
+// useFastestWrites switches the destination to its fastest write strategy,
+// which still follows the upsert semantics, as batches can be written twice
+// on resume. UpsertBulk already is the fastest upsert of the other databases.
+func useFastestWrites(dbInstance db.Database) {
+	if mongo, ok := dbInstance.(*db.MongoDB); ok {
+		opts := mongo.Options()
+		opts.UnorderedBulk = true
+		mongo.SetOptions(opts)
+	}
+}
+
+// verifyMigration compares the row counts of both databases. The state file is
+// only removed after a successful verification.
+func verifyMigration(from, to db.Database, statePath string) error {
+	fromCount, err := from.Count()
+	if err != nil {
+		return err
+	}
+
+	toCount, err := to.Count()
+	if err != nil {
+		return err
+	}
+
+	if fromCount != toCount {
+		return fmt.Errorf("migrate: verification failed: %v has %v rows, %v has %v rows", from.GetName(), fromCount, to.GetName(), toCount)
+	}
+
+	log.Printf("Verified %v rows in %v and %v", toCount, from.GetName(), to.GetName())
+	return os.Remove(statePath)
+}
+
+func readMigrationFile(path string) (migrationFile, bool, error) {
+	var file migrationFile
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, false, nil
+	}
+	if err != nil {
+		return file, false, err
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, false, fmt.Errorf("migrate: invalid state file %v: %v", path, err)
+	}
+
+	return file, true, nil
+}
+
+// writeMigrationFile replaces the state file through a rename, so a crash never
+// leaves a partial file behind.
+func writeMigrationFile(path string, file migrationFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"timeseries-benchmark/db"
+)
+
+// The values of every value type have to be read back as they were written.
+// Only duckdb and parquet are tested, as they don't need a running server.
+func TestValueTypes(t *testing.T) {
+	for _, valueType := range db.VALUE_TYPES {
+		t.Run(string(valueType), func(t *testing.T) {
+			duckDb, parquet := localDatabases(t)
+
+			fake := db.GenerateFakeDataOfType(100, valueType)
+			if valueType == db.VALUE_TYPE_INT64 {
+				// Above 2^53, where a float64 would lose the precision.
+				fake[len(fake)-1].Value = int64(1<<62 + 1)
+			}
+
+			for _, dbInstance := range []db.Database{duckDb, parquet} {
+				if err := dbInstance.SetSchema(db.Schema{ValueType: valueType}); err != nil {
+					t.Fatalf("Error: %v", err)
+				}
+				if err := dbInstance.Setup(); err != nil {
+					t.Fatalf("Error: %v", err)
+				}
+				if err := dbInstance.UpsertBulk(fake); err != nil {
+					t.Fatalf("Error: %v", err)
+				}
+
+				for _, want := range []db.DataObject{fake[0], fake[len(fake)-1]} {
+					doc, err := dbInstance.GetOne(want.StartTime, want.Interval, want.Area)
+					if err != nil {
+						t.Fatalf("%v: Error: %v", dbInstance.GetName(), err)
+					}
+					if doc.Value != want.Value {
+						t.Errorf("%v: expected value %v, got %v", dbInstance.GetName(), want.Value, doc.Value)
+					}
+				}
+
+				_, err := dbInstance.GetAggregated(db.BUCKET_DAY, fake[0].StartTime, fake[len(fake)-1].StartTime)
+				if valueType == db.VALUE_TYPE_STRING && err == nil {
+					t.Errorf("%v: expected the aggregation of strings to fail", dbInstance.GetName())
+				}
+				if valueType != db.VALUE_TYPE_STRING && err != nil {
+					t.Errorf("%v: Error: %v", dbInstance.GetName(), err)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		duckDb, err := db.NewDuckDB("duckdb", "")
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		defer duckDb.Close()
+
+		if err := duckDb.SetSchema(db.Schema{ValueType: "decimal"}); err == nil {
+			t.Errorf("Expected an error for an unknown value type")
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		duckDb, err := db.NewDuckDB("duckdb", "")
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		defer duckDb.Close()
+
+		if err := duckDb.SetSchema(db.Schema{ValueType: db.VALUE_TYPE_INT64, Fields: 2}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := duckDb.Setup(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		for _, values := range [][]any{{1.5, int64(1)}, {int64(1), "ok"}, {int64(1), int64(2), int64(3)}} {
+			doc := db.GenerateFakeData(1)[0]
+			doc.Value, doc.Values = values[0], values[1:]
+			if err := duckDb.UpsertBulk([]db.DataObject{doc}); err == nil {
+				t.Errorf("Expected an error for the values %v", values)
+			}
+		}
+	})
+}
+
+// The values of every field of a wide schema have to be read back as they
+// were written, by every read of the rows.
+func TestWideSchema(t *testing.T) {
+	schema := db.Schema{Fields: 5}
+	fake := db.GenerateFakeDataForSchema(100, schema)
+
+	duckDb, parquet := localDatabases(t)
+
+	for _, dbInstance := range []db.Database{duckDb, parquet} {
+		if err := dbInstance.SetSchema(schema); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := dbInstance.Setup(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		// The upserts have to overwrite every field.
+		revised := fake[len(fake)-1]
+		revised.Values = []any{1.0, 2.0, 3.0, 4.0}
+		if err := dbInstance.UpsertSingle([]db.DataObject{revised}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		docs, err := dbInstance.GetOrderedWithLimit(len(fake))
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		want := append(fake[:len(fake)-1:len(fake)-1], revised)
+		for i, doc := range docs {
+			expected := want[len(want)-1-i]
+			if len(doc.Values) != len(expected.Values) {
+				t.Fatalf("%v: expected %v values, got %v", dbInstance.GetName(), len(expected.Values), len(doc.Values))
+			}
+			for j := range doc.Values {
+				if doc.Values[j] != expected.Values[j] {
+					t.Errorf("%v: expected value_%v %v, got %v", dbInstance.GetName(), j+1, expected.Values[j], doc.Values[j])
+				}
+			}
+		}
+
+		latest, err := dbInstance.GetLatestPerArea()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if len(latest) != 1 || latest[0].Values[3] != 4.0 {
+			t.Errorf("%v: expected the revised values in the latest row, got %v", dbInstance.GetName(), latest)
+		}
+	}
+}
+
+// GetByLabels has to return the rows which have all of the given labels, with
+// the labels read back as they were written.
+func TestLabels(t *testing.T) {
+	NUM_AREAS := 12
+	HOURS := 10
+	fake := db.GenerateFakeLabeledSeries(NUM_AREAS, HOURS)
+
+	duckDb, parquet := localDatabases(t)
+
+	filters := []struct {
+		labels map[string]string
+		areas  int
+	}{
+		{map[string]string{"device": "device-0005"}, 1},
+		{map[string]string{"region": db.FAKE_LABEL_REGIONS[0]}, NUM_AREAS / len(db.FAKE_LABEL_REGIONS)},
+		{map[string]string{"region": db.FAKE_LABEL_REGIONS[0], "kind": db.FAKE_LABEL_KINDS[0]}, 1},
+		{map[string]string{"region": "nowhere"}, 0},
+	}
+
+	for _, dbInstance := range []db.Database{duckDb, parquet} {
+		if _, err := dbInstance.GetByLabels(filters[0].labels, 1); err == nil {
+			t.Errorf("%v: expected an error without labels in the schema", dbInstance.GetName())
+		}
+
+		if err := dbInstance.SetSchema(db.Schema{Labels: true}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := dbInstance.Setup(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := dbInstance.UpsertBulk(fake); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		for _, filter := range filters {
+			docs, err := dbInstance.GetByLabels(filter.labels, len(fake))
+			if err != nil {
+				t.Fatalf("%v: Error: %v", dbInstance.GetName(), err)
+			}
+			if len(docs) != filter.areas*HOURS {
+				t.Errorf("%v: expected %v docs for %v, got %v", dbInstance.GetName(), filter.areas*HOURS, filter.labels, len(docs))
+			}
+
+			for _, doc := range docs {
+				for key, value := range filter.labels {
+					if doc.Labels[key] != value {
+						t.Fatalf("%v: expected %v=%v, got %v", dbInstance.GetName(), key, value, doc.Labels)
+					}
+				}
+			}
+		}
+
+		latest, err := dbInstance.GetLatestPerArea()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if len(latest) != NUM_AREAS || len(latest[0].Labels) != 3 {
+			t.Errorf("%v: expected the labels of %v areas, got %v", dbInstance.GetName(), NUM_AREAS, latest)
+		}
+	}
+}
+
+// mysql indexes the labels of MySQLOptions.IndexedLabels, which have to be
+// valid keys of a JSON path, and rejects the values of those labels which the
+// cast of the index would truncate.
+func TestMySQLIndexedLabels(t *testing.T) {
+	requireServer(t, db.PORT_MYSQL)
+
+	dbMysql, err := db.NewMySQLDB("mysql", "localhost", db.PORT_MYSQL, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer dbMysql.Close()
+
+	if err := dbMysql.SetSchema(db.Schema{Labels: true}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	dbMysql.SetOptions(db.MySQLOptions{IndexedLabels: []string{"device', 1)"}})
+	if err := dbMysql.Setup(); err == nil {
+		t.Errorf("expected an error for an invalid label key")
+	}
+
+	dbMysql.SetOptions(db.MySQLOptions{IndexedLabels: []string{"site"}})
+	if err := dbMysql.Setup(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	fake := db.GenerateFakeLabeledSeries(2, 10)
+	for i := range fake {
+		fake[i].Labels = map[string]string{"site": fmt.Sprintf("site-%v", fake[i].Area)}
+	}
+	if err := dbMysql.UpsertBulk(fake); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	docs, err := dbMysql.GetByLabels(map[string]string{"site": "site-" + fake[0].Area}, len(fake))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(docs) != 10 {
+		t.Errorf("expected 10 docs, got %v", len(docs))
+	}
+
+	long := fake[0]
+	long.Labels = map[string]string{"site": strings.Repeat("s", db.MYSQL_MAX_LABEL_LENGTH+1)}
+	if err := dbMysql.UpsertBulk([]db.DataObject{long}); err == nil {
+		t.Errorf("expected an error for a label longer than %v characters", db.MYSQL_MAX_LABEL_LENGTH)
+	}
+}
+
+// The normalized schema has to read back the rows through the view, keep the
+// created_at of an upserted row and change the source of the whole series.
+func TestNormalizedSchema(t *testing.T) {
+	NUM_AREAS := 5
+	HOURS := 20
+	fake := db.GenerateFakeSeries(NUM_AREAS, HOURS)
+
+	duckDb, parquet := localDatabases(t)
+
+	if err := parquet.SetSchema(db.Schema{Normalized: true}); err == nil {
+		t.Errorf("expected an error for the normalized schema of parquet")
+	}
+
+	// Every setup has to replace the table or the view of the previous one.
+	for _, schema := range []db.Schema{{}, {Normalized: true, Labels: true}, {}, {Normalized: true}} {
+		if err := duckDb.SetSchema(schema); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := duckDb.Setup(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+
+	if err := duckDb.UpsertBulk(fake); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	revised := fake[len(fake)-1]
+	revised.CreatedAt = revised.CreatedAt.Add(time.Hour)
+	revised.Source = "revised-source"
+	revised.Value = 42.0
+	if err := duckDb.UpsertSingle([]db.DataObject{revised}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	count, err := duckDb.Count()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if count != int64(len(fake)) {
+		t.Errorf("expected %v rows, got %v", len(fake), count)
+	}
+
+	doc, err := duckDb.GetOne(revised.StartTime, revised.Interval, revised.Area)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if doc.Value != 42.0 || doc.Source != revised.Source || !closeTo(doc.CreatedAt, fake[len(fake)-1].CreatedAt) {
+		t.Errorf("expected the revised value and source with the original created_at, got %v", doc)
+	}
+
+	first, err := duckDb.GetOne(fake[NUM_AREAS-1].StartTime, revised.Interval, revised.Area)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if first.Source != revised.Source {
+		t.Errorf("expected the source of the series in every row, got %v", first.Source)
+	}
+
+	latest, err := duckDb.GetLatestPerArea()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(latest) != NUM_AREAS || latest[NUM_AREAS-1].Value != 42.0 {
+		t.Errorf("expected the revised row in the latest of %v areas, got %v", NUM_AREAS, latest)
+	}
+	// A table which is written again without Setup, e.g. by a resumed
+	// migration, resolves the ids of its existing series.
+	path := filepath.Join(t.TempDir(), "duckdb.db")
+	for range 2 {
+		fileDb, err := db.NewDuckDB("duckdb", path)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := fileDb.SetSchema(db.Schema{Normalized: true}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if count, _ := fileDb.Count(); count == 0 {
+			if err := fileDb.Setup(); err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+		}
+		if err := fileDb.UpsertBulk(fake); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if count, err := fileDb.Count(); err != nil || count != int64(len(fake)) {
+			t.Errorf("expected %v rows, got %v (%v)", len(fake), count, err)
+		}
+		fileDb.Close()
+	}
+}
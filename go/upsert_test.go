@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"timeseries-benchmark/db"
+)
+
+// The tests only use the database servers of the docker stack if
+// BENCH_DB_TESTS=1 is set, so go test works without them.
+const DB_TESTS_ENV = "BENCH_DB_TESTS"
+
+// requireServer skips the test unless DB_TESTS_ENV is set and the server
+// accepts connections on the port within a second, so the clients never wait
+// for their own (much longer) connection timeouts.
+func requireServer(t *testing.T, port int) {
+	t.Helper()
+
+	if os.Getenv(DB_TESTS_ENV) != "1" {
+		t.Skipf("set %v=1 to run against the database servers", DB_TESTS_ENV)
+	}
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), time.Second)
+	if err != nil {
+		t.Skipf("the server on port %v is not available: %v", port, err)
+	}
+	conn.Close()
+}
+
+// localDatabases returns the databases which don't need a server, duckdb in
+// memory and parquet in a temporary directory. Both are closed at the end of
+// the test.
+func localDatabases(t *testing.T) (*db.DuckDB, *db.ParquetDB) {
+	t.Helper()
+
+	duckDb, err := db.NewDuckDB("duckdb", "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	t.Cleanup(func() { duckDb.Close() })
+
+	parquet, err := db.NewParquetDB("parquet", t.TempDir(), db.PARQUET_PARTITION_MONTH)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	t.Cleanup(func() { parquet.Close() })
+
+	return duckDb, parquet
+}
+
+// Every database has to follow the upsert semantics of db/upsert.go: created_at
+// keeps the value of the first insert, while the updatable fields are
+// overwritten. mongodb is tested with a plain and a time-series collection,
+// which has its own upserts on the metaField (and the delete + insert fallback
+// of the servers which can't upsert into it). duckdb runs in memory and
+// parquet writes into a temporary directory, the servers are opt-in (see
+// requireServer).
+func TestUpsertSemantics(t *testing.T) {
+	connectors := []struct {
+		name    string
+		port    int // 0 if the database doesn't need a server
+		connect func() (db.Database, error)
+	}{
+		{"mongodb", db.PORT_MONGO, func() (db.Database, error) {
+			return db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+		}},
+		{"mongodb-ts", db.PORT_MONGO, func() (db.Database, error) {
+			mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+			if err != nil {
+				return nil, err
+			}
+			mongo.SetOptions(db.MongoOptions{TimeSeries: true})
+			return mongo, nil
+		}},
+		{"mongodb-ts-fallback", db.PORT_MONGO, func() (db.Database, error) {
+			mongo, err := db.NewMongoDB("mongodb", "localhost", db.PORT_MONGO, db.DB_USERNAME, db.DB_PASSWORD)
+			if err != nil {
+				return nil, err
+			}
+			mongo.SetOptions(db.MongoOptions{TimeSeries: true, ForceUpsertFallback: true})
+			return mongo, nil
+		}},
+		{"pg-ntv", db.PORT_POSTGRES, func() (db.Database, error) {
+			return db.NewPostgresDB("pg-ntv", "localhost", db.PORT_POSTGRES, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, false)
+		}},
+		{"pg-tsc", db.PORT_TIMESCALE, func() (db.Database, error) {
+			return db.NewPostgresDB("pg-tsc", "localhost", db.PORT_TIMESCALE, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME, true)
+		}},
+		{"mysql", db.PORT_MYSQL, func() (db.Database, error) {
+			return db.NewMySQLDB("mysql", "localhost", db.PORT_MYSQL, db.DB_USERNAME, db.DB_PASSWORD, db.DB_NAME)
+		}},
+		{"duckdb", 0, func() (db.Database, error) {
+			return db.NewDuckDB("duckdb", "")
+		}},
+		{"parquet", 0, func() (db.Database, error) {
+			return db.NewParquetDB("parquet", t.TempDir(), db.PARQUET_PARTITION_DAY)
+		}},
+	}
+
+	original := db.GenerateFakeData(10)
+	revised := make([]db.DataObject, len(original))
+	for i, doc := range original {
+		doc.CreatedAt = doc.CreatedAt.Add(24 * time.Hour)
+		doc.UpdatedAt = doc.UpdatedAt.Add(24 * time.Hour)
+		doc.Source = "revised-source"
+		doc.Value = doc.Value.(float64) + 1
+		revised[i] = doc
+	}
+
+	for _, c := range connectors {
+		t.Run(c.name, func(t *testing.T) {
+			if c.port != 0 {
+				requireServer(t, c.port)
+			}
+
+			dbInstance, err := c.connect()
+			if err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+			defer dbInstance.Close()
+
+			if err := dbInstance.Setup(); err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+
+			if err := dbInstance.UpsertBulk(original); err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+
+			// Both of the upsert methods have to follow the semantics.
+			half := len(revised) / 2
+			if err := dbInstance.UpsertSingle(revised[:half]); err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+			if err := dbInstance.UpsertBulk(revised[half:]); err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+
+			docs, err := dbInstance.GetOrderedWithLimit(len(original) * 2)
+			if err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+			if len(docs) != len(original) {
+				t.Fatalf("Expected %v docs, got %v", len(original), len(docs))
+			}
+
+			byStartTime := make(map[int64]db.DataObject, len(docs))
+			for _, doc := range docs {
+				byStartTime[doc.StartTime.Unix()] = doc
+			}
+
+			for i, want := range revised {
+				got, ok := byStartTime[want.StartTime.Unix()]
+				if !ok {
+					t.Fatalf("Missing doc with start_time %v", want.StartTime)
+				}
+
+				// mysql stores DATETIME with a precision of seconds
+				if !closeTo(got.CreatedAt, original[i].CreatedAt) {
+					t.Errorf("created_at of %v was overwritten: got %v, want %v", want.StartTime, got.CreatedAt, original[i].CreatedAt)
+				}
+				if !closeTo(got.UpdatedAt, want.UpdatedAt) {
+					t.Errorf("updated_at of %v was not updated: got %v, want %v", want.StartTime, got.UpdatedAt, want.UpdatedAt)
+				}
+				if got.Source != want.Source || got.Value != want.Value {
+					t.Errorf("source / value of %v were not updated: got %v / %v, want %v / %v",
+						want.StartTime, got.Source, got.Value, want.Source, want.Value)
+				}
+			}
+		})
+	}
+}
+
+func closeTo(a, b time.Time) bool {
+	return a.Sub(b).Abs() < time.Second
+}